@@ -0,0 +1,79 @@
+package smoketest
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"time"
+
+	"github.com/bcatubig/protohackers/metrics"
+	"github.com/bcatubig/protohackers/server"
+)
+
+type Server struct {
+	*server.Server
+
+	logger  *slog.Logger
+	metrics *metrics.Registry
+}
+
+type ServerOpt func(s *Server)
+
+func WithLogger(l *slog.Logger) ServerOpt {
+	return func(s *Server) {
+		s.logger = l
+	}
+}
+
+func WithMetrics(r *metrics.Registry) ServerOpt {
+	return func(s *Server) {
+		s.metrics = r
+	}
+}
+
+func NewServer(addr string, opts ...ServerOpt) (*Server, error) {
+	s := &Server{}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.logger == nil {
+		s.logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+	}
+
+	if s.metrics == nil {
+		s.metrics = metrics.NewRegistry()
+	}
+
+	srv, err := server.New(addr, server.HandlerFunc(s.handle), server.WithLogger(s.logger), server.WithMetrics(s.metrics, "smoke"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.Server = srv
+
+	return s, nil
+}
+
+func (s *Server) handle(ctx context.Context, c net.Conn) {
+	c.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// that replaced the deadline shutdown sets to interrupt us
+	if ctx.Err() != nil {
+		return
+	}
+
+	s.logger.Info("handling client", "addr", c.RemoteAddr())
+
+	_, err := io.Copy(c, c)
+
+	if err != nil {
+		s.logger.Error(err.Error())
+	}
+
+	s.logger.Info("closing connection", "addr", c.RemoteAddr())
+}
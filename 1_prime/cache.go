@@ -0,0 +1,160 @@
+package prime
+
+import (
+	"container/list"
+	"math/big"
+	"sync"
+)
+
+const (
+	defaultSieveBound = 1 << 20
+	defaultCacheSize  = 10000
+)
+
+// primeChecker answers primality tests from a sieve for numbers below its
+// bound and from an LRU cache of earlier results for everything else. A nil
+// *primeChecker tests every number from scratch.
+type primeChecker struct {
+	bound uint64
+	sieve []uint64
+
+	cache *lruCache
+}
+
+func newPrimeChecker(sieveBound, cacheSize int) *primeChecker {
+	p := &primeChecker{}
+
+	if sieveBound > 2 {
+		p.bound = uint64(sieveBound)
+		p.sieve = buildSieve(p.bound)
+	}
+
+	if cacheSize > 0 {
+		p.cache = newLRUCache(cacheSize)
+	}
+
+	return p
+}
+
+func (p *primeChecker) isPrime(n *big.Int) bool {
+	if n.Sign() <= 0 {
+		return false
+	}
+
+	if p == nil {
+		return n.ProbablyPrime(0)
+	}
+
+	if n.IsUint64() && n.Uint64() < p.bound {
+		v := n.Uint64()
+		return p.sieve[v/64]&(1<<(v%64)) != 0
+	}
+
+	if p.cache == nil {
+		return n.ProbablyPrime(0)
+	}
+
+	key := string(n.Bytes())
+
+	if prime, ok := p.cache.get(key); ok {
+		return prime
+	}
+
+	prime := n.ProbablyPrime(0)
+	p.cache.add(key, prime)
+
+	return prime
+}
+
+// buildSieve returns a bitset with bit n set when n is prime, for n < bound.
+func buildSieve(bound uint64) []uint64 {
+	sieve := make([]uint64, (bound+63)/64)
+
+	for i := range sieve {
+		sieve[i] = ^uint64(0)
+	}
+
+	unset := func(n uint64) {
+		sieve[n/64] &^= 1 << (n % 64)
+	}
+
+	unset(0)
+	unset(1)
+
+	for i := uint64(2); i*i < bound; i++ {
+		if sieve[i/64]&(1<<(i%64)) == 0 {
+			continue
+		}
+
+		for j := i * i; j < bound; j += i {
+			unset(j)
+		}
+	}
+
+	for n := bound; n < uint64(len(sieve))*64; n++ {
+		unset(n)
+	}
+
+	return sieve
+}
+
+type lruEntry struct {
+	key   string
+	prime bool
+}
+
+// lruCache is a fixed-size, concurrency-safe cache of primality results that
+// evicts the least recently used entry when full.
+type lruCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+func newLRUCache(size int) *lruCache {
+	return &lruCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element, size),
+	}
+}
+
+func (c *lruCache) get(key string) (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return false, false
+	}
+
+	c.order.MoveToFront(e)
+
+	return e.Value.(*lruEntry).prime, true
+}
+
+func (c *lruCache) add(key string, prime bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, prime: prime})
+
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
+
+func (c *lruCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
@@ -0,0 +1,110 @@
+package prime
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrimeChecker(t *testing.T) {
+	t.Run("sieve agrees with ProbablyPrime", func(t *testing.T) {
+		p := newPrimeChecker(10000, 0)
+
+		for i := int64(-10); i < 10000; i++ {
+			n := big.NewInt(i)
+			assert.Equal(t, n.ProbablyPrime(0), p.isPrime(n), "n=%d", i)
+		}
+	})
+
+	t.Run("numbers above the sieve are cached", func(t *testing.T) {
+		p := newPrimeChecker(100, 10)
+
+		assert.True(t, p.isPrime(big.NewInt(1000003)))
+		assert.False(t, p.isPrime(big.NewInt(1000001)))
+		assert.True(t, p.isPrime(big.NewInt(1000003)))
+		assert.Equal(t, 2, p.cache.len())
+	})
+
+	t.Run("nil checker", func(t *testing.T) {
+		var p *primeChecker
+
+		assert.True(t, p.isPrime(big.NewInt(7)))
+		assert.False(t, p.isPrime(big.NewInt(-7)))
+	})
+}
+
+func TestLRUCache(t *testing.T) {
+	c := newLRUCache(2)
+
+	c.add("a", true)
+	c.add("b", false)
+
+	_, ok := c.get("a")
+	assert.True(t, ok)
+
+	// "b" is now the least recently used entry
+	c.add("c", true)
+
+	_, ok = c.get("b")
+	assert.False(t, ok)
+
+	prime, ok := c.get("a")
+	assert.True(t, ok)
+	assert.True(t, prime)
+
+	assert.Equal(t, 2, c.len())
+}
+
+// hotNumbers mimics a load test that keeps asking about the same numbers.
+var hotNumbers = func() []*big.Int {
+	var result []*big.Int
+
+	for i := int64(0); i < 64; i++ {
+		result = append(result, big.NewInt(1000+i*7919))
+		result = append(result, new(big.Int).Lsh(big.NewInt(1+i), 70))
+	}
+
+	return result
+}()
+
+func BenchmarkIsPrimeUncached(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		hotNumbers[i%len(hotNumbers)].ProbablyPrime(0)
+	}
+}
+
+func BenchmarkIsPrimeChecker(b *testing.B) {
+	p := newPrimeChecker(defaultSieveBound, defaultCacheSize)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		p.isPrime(hotNumbers[i%len(hotNumbers)])
+	}
+}
+
+func BenchmarkIsPrimeSieve(b *testing.B) {
+	p := newPrimeChecker(defaultSieveBound, 0)
+	n := big.NewInt(999983)
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		p.isPrime(n)
+	}
+}
+
+func BenchmarkIsPrimeSieveUncached(b *testing.B) {
+	n := big.NewInt(999983)
+
+	for i := 0; i < b.N; i++ {
+		n.ProbablyPrime(0)
+	}
+}
+
+func BenchmarkBuildSieve(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		buildSieve(defaultSieveBound)
+	}
+}
@@ -0,0 +1,20 @@
+package prime
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/bcatubig/protohackers/golden"
+	"github.com/bcatubig/protohackers/server"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGolden(t *testing.T) {
+	s, err := NewServer("127.0.0.1:0", WithLogger(slog.New(slog.DiscardHandler)))
+	require.NoError(t, err)
+
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+
+	golden.Run(t, "testdata", server.HandlerFunc(s.handle))
+}
@@ -0,0 +1,226 @@
+package prime
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Standard JSON-RPC 2.0 error codes.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcInternalError  = -32603
+)
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    string `json:"data,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  any             `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// isJSONRPC reports whether a line is a JSON-RPC 2.0 request or batch rather
+// than a request in the original protocol. A line that doesn't parse but
+// mentions a "jsonrpc" key counts, so its sender gets a JSON-RPC parse error
+// rather than the original protocol's malformed response.
+func isJSONRPC(data []byte) bool {
+	trimmed := bytes.TrimSpace(data)
+
+	if len(trimmed) == 0 {
+		return false
+	}
+
+	if trimmed[0] == '[' {
+		return true
+	}
+
+	var probe struct {
+		JSONRPC *json.RawMessage `json:"jsonrpc"`
+	}
+
+	if err := json.Unmarshal(trimmed, &probe); err != nil {
+		return bytes.Contains(trimmed, []byte(`"jsonrpc"`))
+	}
+
+	return probe.JSONRPC != nil
+}
+
+// handleRPC answers a JSON-RPC 2.0 request or batch. It returns nil when
+// there is nothing to send back, which is the case for notifications.
+func (s *Server) handleRPC(data []byte) []byte {
+	trimmed := bytes.TrimSpace(data)
+
+	var out any
+
+	if trimmed[0] == '[' {
+		var batch []json.RawMessage
+
+		if err := json.Unmarshal(trimmed, &batch); err != nil {
+			out = newRPCError(nil, rpcParseError, "Parse error", err)
+		} else if len(batch) == 0 {
+			out = newRPCError(nil, rpcInvalidRequest, "Invalid Request", errors.New("empty batch"))
+		} else {
+			responses := []*rpcResponse{}
+
+			for _, raw := range batch {
+				if resp := s.callRPC(raw); resp != nil {
+					responses = append(responses, resp)
+				}
+			}
+
+			if len(responses) == 0 {
+				return nil
+			}
+
+			out = responses
+		}
+	} else {
+		resp := s.callRPC(trimmed)
+
+		if resp == nil {
+			return nil
+		}
+
+		out = resp
+	}
+
+	result, err := json.Marshal(out)
+	if err != nil {
+		s.logger.Error("error encoding json-rpc response", "error", err.Error())
+		return nil
+	}
+
+	return append(result, '\n')
+}
+
+// callRPC runs a single JSON-RPC request. Notifications, requests without an
+// id, get a nil response.
+func (s *Server) callRPC(raw json.RawMessage) *rpcResponse {
+	start := time.Now()
+
+	var envelope map[string]json.RawMessage
+
+	if err := json.Unmarshal(raw, &envelope); err != nil {
+		var syntaxErr *json.SyntaxError
+
+		if errors.As(err, &syntaxErr) {
+			s.requests.Malformed()
+			return newRPCError(nil, rpcParseError, "Parse error", err)
+		}
+	}
+
+	if envelope == nil {
+		s.requests.Malformed()
+		return newRPCError(nil, rpcInvalidRequest, "Invalid Request", errors.New("request must be an object"))
+	}
+
+	id, hasID := envelope["id"]
+
+	if hasID && !isValidID(id) {
+		s.requests.Malformed()
+		return newRPCError(nil, rpcInvalidRequest, "Invalid Request", errors.New("id must be a string, number or null"))
+	}
+
+	var version, name string
+
+	if json.Unmarshal(envelope["jsonrpc"], &version) != nil || version != "2.0" {
+		s.requests.Malformed()
+		return newRPCError(id, rpcInvalidRequest, "Invalid Request", errors.New(`jsonrpc must be "2.0"`))
+	}
+
+	if !isJSONString(envelope["method"]) || json.Unmarshal(envelope["method"], &name) != nil {
+		s.requests.Malformed()
+		return newRPCError(id, rpcInvalidRequest, "Invalid Request", errors.New("method must be a string"))
+	}
+
+	m, ok := methods[name]
+	if !ok {
+		return reply(hasID, newRPCError(id, rpcMethodNotFound, "Method not found", fmt.Errorf("%w: %q", ErrInvalidMethod, name)))
+	}
+
+	fields, err := paramFields(m, envelope["params"])
+	if err != nil {
+		return reply(hasID, newRPCError(id, rpcInvalidParams, "Invalid params", err))
+	}
+
+	req := &request{Method: name, fields: fields, primes: s.primes}
+
+	result, err := req.call()
+
+	switch {
+	case errors.Is(err, ErrMissingField), errors.Is(err, ErrInvalidFieldType), errors.Is(err, ErrInvalidArgument):
+		return reply(hasID, newRPCError(id, rpcInvalidParams, "Invalid params", err))
+	case err != nil:
+		return reply(hasID, newRPCError(id, rpcInternalError, "Internal error", err))
+	}
+
+	s.requests.Observe(name, start)
+
+	return reply(hasID, &rpcResponse{JSONRPC: "2.0", Result: result, ID: id})
+}
+
+// paramFields maps by-name or by-position params onto the fields a method
+// reads from a request.
+func paramFields(m method, raw json.RawMessage) (map[string]json.RawMessage, error) {
+	fields := map[string]json.RawMessage{}
+
+	if len(raw) == 0 || string(raw) == "null" {
+		return fields, nil
+	}
+
+	switch raw[0] {
+	case '{':
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			return nil, err
+		}
+	case '[':
+		var positional []json.RawMessage
+
+		if err := json.Unmarshal(raw, &positional); err != nil {
+			return nil, err
+		}
+
+		if len(positional) > len(m.params) {
+			return nil, fmt.Errorf("expected at most %d params, got %d", len(m.params), len(positional))
+		}
+
+		for i, p := range positional {
+			fields[m.params[i]] = p
+		}
+	default:
+		return nil, errors.New("params must be an object or an array")
+	}
+
+	return fields, nil
+}
+
+func isValidID(id json.RawMessage) bool {
+	return len(id) > 0 && (isJSONString(id) || isJSONNumber(id) || string(id) == "null")
+}
+
+func newRPCError(id json.RawMessage, code int, message string, err error) *rpcResponse {
+	return &rpcResponse{
+		JSONRPC: "2.0",
+		Error:   &rpcError{Code: code, Message: message, Data: err.Error()},
+		ID:      id,
+	}
+}
+
+func reply(hasID bool, resp *rpcResponse) *rpcResponse {
+	if !hasID {
+		return nil
+	}
+
+	return resp
+}
@@ -0,0 +1,117 @@
+package prime
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/bcatubig/protohackers/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsJSONRPC(t *testing.T) {
+	assert.True(t, isJSONRPC([]byte(`{"jsonrpc":"2.0","method":"isPrime","params":[7],"id":1}`)))
+	assert.True(t, isJSONRPC([]byte(`[{"jsonrpc":"2.0","method":"isPrime","params":[7],"id":1}]`)))
+	assert.False(t, isJSONRPC([]byte(`{"method":"isPrime","number":7}`)))
+	assert.True(t, isJSONRPC([]byte(`{"jsonrpc":"2.0",`)))
+	assert.False(t, isJSONRPC([]byte(`{"method":`)))
+}
+
+func TestHandleRPC(t *testing.T) {
+	s := &Server{
+		logger:   slog.New(slog.DiscardHandler),
+		requests: metrics.NewRegistry().Requests("prime"),
+	}
+
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			"named params",
+			`{"jsonrpc":"2.0","method":"isPrime","params":{"number":7},"id":1}`,
+			`{"jsonrpc":"2.0","result":{"method":"isPrime","prime":true},"id":1}`,
+		},
+		{
+			"positional params",
+			`{"jsonrpc":"2.0","method":"primesInRange","params":[10,20],"id":"a"}`,
+			`{"jsonrpc":"2.0","result":{"method":"primesInRange","primes":[11,13,17,19]},"id":"a"}`,
+		},
+		{
+			"notification",
+			`{"jsonrpc":"2.0","method":"isPrime","params":[7]}`,
+			``,
+		},
+		{
+			"parse error",
+			`[{"jsonrpc":"2.0",`,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error","data":"unexpected end of JSON input"},"id":null}`,
+		},
+		{
+			"truncated request",
+			`{"jsonrpc":"2.0",`,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error","data":"unexpected end of JSON input"},"id":null}`,
+		},
+		{
+			"method not found",
+			`{"jsonrpc":"2.0","method":"isEven","params":[7],"id":2}`,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"Method not found","data":"request method is not supported: \"isEven\""},"id":2}`,
+		},
+		{
+			"invalid params",
+			`{"jsonrpc":"2.0","method":"isPrime","params":{"number":"7"},"id":3}`,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid params","data":"request field has the wrong type: number must be a number"},"id":3}`,
+		},
+		{
+			"too many params",
+			`{"jsonrpc":"2.0","method":"isPrime","params":[7,8],"id":4}`,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"Invalid params","data":"expected at most 1 params, got 2"},"id":4}`,
+		},
+		{
+			"wrong version",
+			`{"jsonrpc":"1.0","method":"isPrime","params":[7],"id":5}`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request","data":"jsonrpc must be \"2.0\""},"id":5}`,
+		},
+		{
+			"empty batch",
+			`[]`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request","data":"empty batch"},"id":null}`,
+		},
+		{
+			"batch",
+			`[{"jsonrpc":"2.0","method":"isPrime","params":[7],"id":1},{"jsonrpc":"2.0","method":"gcd","params":[[4,6]]},1,{"jsonrpc":"2.0","method":"gcd","params":[[4,6]],"id":2}]`,
+			`[{"jsonrpc":"2.0","result":{"method":"isPrime","prime":true},"id":1},{"jsonrpc":"2.0","error":{"code":-32600,"message":"Invalid Request","data":"request must be an object"},"id":null},{"jsonrpc":"2.0","result":{"method":"gcd","gcd":2},"id":2}]`,
+		},
+		{
+			"batch of notifications",
+			`[{"jsonrpc":"2.0","method":"isPrime","params":[7]}]`,
+			``,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := s.handleRPC([]byte(tt.data + "\n"))
+
+			if tt.want == "" {
+				assert.Nil(t, got)
+				return
+			}
+
+			assert.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestProcessRPCParseError(t *testing.T) {
+	s := &Server{
+		logger:   slog.New(slog.DiscardHandler),
+		requests: metrics.NewRegistry().Requests("prime"),
+		jsonrpc:  true,
+	}
+
+	r := s.process([]byte(`{"jsonrpc":"2.0",`+"\n"), "test")
+
+	assert.False(t, r.closeConn)
+	assert.JSONEq(t, `{"jsonrpc":"2.0","error":{"code":-32700,"message":"Parse error","data":"unexpected end of JSON input"},"id":null}`, string(r.out))
+}
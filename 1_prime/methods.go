@@ -0,0 +1,297 @@
+package prime
+
+import (
+	"fmt"
+	"math/big"
+	"math/bits"
+	"slices"
+)
+
+// Input limits for the methods beyond isPrime. They keep a single request
+// from tying up a connection for more than a few milliseconds.
+const (
+	maxIntegerBits    = 4096
+	maxNextPrimeBits  = 1024
+	maxFactorizeBits  = 64
+	maxRangeSpan      = 10000
+	maxGCDNumbers     = 1000
+	maxConfidence     = 100
+	defaultConfidence = 20
+)
+
+// method describes a registered method. params lists the field names in
+// the order used for positional JSON-RPC params; call validates the fields of
+// a request and computes its response.
+type method struct {
+	params []string
+	call   func(r *request) (any, error)
+}
+
+var methods = map[string]method{
+	"isPrime":         {[]string{"number"}, callIsPrime},
+	"isProbablePrime": {[]string{"number", "confidence"}, callIsProbablePrime},
+	"factorize":       {[]string{"number"}, callFactorize},
+	"nextPrime":       {[]string{"number"}, callNextPrime},
+	"primesInRange":   {[]string{"min", "max"}, callPrimesInRange},
+	"gcd":             {[]string{"numbers"}, callGCD},
+}
+
+type response struct {
+	Method string `json:"method"`
+	Prime  bool   `json:"prime"`
+}
+
+type isProbablePrimeResponse struct {
+	Method     string `json:"method"`
+	Prime      bool   `json:"prime"`
+	Confidence int64  `json:"confidence"`
+}
+
+type factorizeResponse struct {
+	Method  string   `json:"method"`
+	Number  uint64   `json:"number"`
+	Factors []uint64 `json:"factors"`
+}
+
+type nextPrimeResponse struct {
+	Method string   `json:"method"`
+	Prime  *big.Int `json:"prime"`
+}
+
+type primesInRangeResponse struct {
+	Method string     `json:"method"`
+	Primes []*big.Int `json:"primes"`
+}
+
+type gcdResponse struct {
+	Method string   `json:"method"`
+	GCD    *big.Int `json:"gcd"`
+}
+
+func callIsPrime(r *request) (any, error) {
+	n, err := r.number("number")
+	if err != nil {
+		return nil, err
+	}
+
+	return &response{Method: r.Method, Prime: isPrime(r.primes, n)}, nil
+}
+
+// callIsProbablePrime runs confidence Miller-Rabin rounds on top of the
+// Baillie-PSW test used by isPrime.
+func callIsProbablePrime(r *request) (any, error) {
+	n, err := r.number("number")
+	if err != nil {
+		return nil, err
+	}
+
+	confidence := big.NewInt(defaultConfidence)
+
+	if r.has("confidence") {
+		confidence, err = r.integer("confidence", 64)
+		if err != nil {
+			return nil, err
+		}
+
+		if confidence.Sign() < 0 || confidence.Int64() > maxConfidence {
+			return nil, fmt.Errorf("%w: confidence must be between 0 and %d", ErrInvalidArgument, maxConfidence)
+		}
+	}
+
+	prime := false
+
+	// like isPrime, a non-integer is just not prime
+	if n.IsInt() {
+		i, err := toInteger("number", n, maxIntegerBits)
+		if err != nil {
+			return nil, err
+		}
+
+		prime = i.ProbablyPrime(int(confidence.Int64()))
+	}
+
+	return &isProbablePrimeResponse{Method: r.Method, Prime: prime, Confidence: confidence.Int64()}, nil
+}
+
+func callFactorize(r *request) (any, error) {
+	n, err := r.integer("number", maxFactorizeBits)
+	if err != nil {
+		return nil, err
+	}
+
+	if n.Sign() < 0 {
+		return nil, fmt.Errorf("%w: number must not be negative", ErrInvalidArgument)
+	}
+
+	return &factorizeResponse{Method: r.Method, Number: n.Uint64(), Factors: factorize(n.Uint64())}, nil
+}
+
+func callNextPrime(r *request) (any, error) {
+	n, err := r.integer("number", maxNextPrimeBits)
+	if err != nil {
+		return nil, err
+	}
+
+	return &nextPrimeResponse{Method: r.Method, Prime: nextPrime(n)}, nil
+}
+
+func callPrimesInRange(r *request) (any, error) {
+	lo, err := r.integer("min", maxIntegerBits)
+	if err != nil {
+		return nil, err
+	}
+
+	hi, err := r.integer("max", maxIntegerBits)
+	if err != nil {
+		return nil, err
+	}
+
+	if lo.Cmp(hi) > 0 {
+		return nil, fmt.Errorf("%w: min greater than max", ErrInvalidArgument)
+	}
+
+	if new(big.Int).Sub(hi, lo).Cmp(big.NewInt(maxRangeSpan)) > 0 {
+		return nil, fmt.Errorf("%w: range spans more than %d numbers", ErrInvalidArgument, maxRangeSpan)
+	}
+
+	primes := []*big.Int{}
+
+	for i := new(big.Int).Set(lo); i.Cmp(hi) <= 0; i.Add(i, big.NewInt(1)) {
+		if r.primes.isPrime(i) {
+			primes = append(primes, new(big.Int).Set(i))
+		}
+	}
+
+	return &primesInRangeResponse{Method: r.Method, Primes: primes}, nil
+}
+
+func callGCD(r *request) (any, error) {
+	numbers, err := r.integers("numbers", maxGCDNumbers, maxIntegerBits)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(numbers) < 2 {
+		return nil, fmt.Errorf("%w: gcd needs at least 2 numbers", ErrInvalidArgument)
+	}
+
+	result := new(big.Int).Abs(numbers[0])
+
+	for _, n := range numbers[1:] {
+		result.GCD(nil, nil, result, new(big.Int).Abs(n))
+	}
+
+	return &gcdResponse{Method: r.Method, GCD: result}, nil
+}
+
+// nextPrime returns the smallest prime strictly greater than n.
+func nextPrime(n *big.Int) *big.Int {
+	if n.Cmp(big.NewInt(2)) < 0 {
+		return big.NewInt(2)
+	}
+
+	p := new(big.Int).Add(n, big.NewInt(1))
+
+	if p.Bit(0) == 0 {
+		if p.Cmp(big.NewInt(2)) == 0 {
+			return p
+		}
+
+		p.Add(p, big.NewInt(1))
+	}
+
+	for !p.ProbablyPrime(0) {
+		p.Add(p, big.NewInt(2))
+	}
+
+	return p
+}
+
+// factorize returns the prime factors of n in ascending order, with
+// repetition. 0 and 1 have no prime factors.
+func factorize(n uint64) []uint64 {
+	factors := []uint64{}
+
+	if n < 2 {
+		return factors
+	}
+
+	for _, p := range []uint64{2, 3, 5, 7, 11, 13, 17, 19, 23, 29, 31, 37} {
+		for n%p == 0 {
+			factors = append(factors, p)
+			n /= p
+		}
+	}
+
+	stack := []uint64{n}
+
+	for len(stack) > 0 {
+		m := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if m == 1 {
+			continue
+		}
+
+		if new(big.Int).SetUint64(m).ProbablyPrime(0) {
+			factors = append(factors, m)
+			continue
+		}
+
+		d := pollardRho(m)
+		stack = append(stack, d, m/d)
+	}
+
+	slices.Sort(factors)
+
+	return factors
+}
+
+// pollardRho returns a non-trivial factor of the odd composite n.
+func pollardRho(n uint64) uint64 {
+	for c := uint64(1); ; c++ {
+		f := func(v uint64) uint64 {
+			return addMod(mulMod(v, v, n), c, n)
+		}
+
+		x, y, d := uint64(2), uint64(2), uint64(1)
+
+		for d == 1 {
+			x = f(x)
+			y = f(f(y))
+
+			if x > y {
+				d = gcd(x-y, n)
+			} else {
+				d = gcd(y-x, n)
+			}
+		}
+
+		if d != n {
+			return d
+		}
+	}
+}
+
+func mulMod(a, b, m uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	return bits.Rem64(hi, lo, m)
+}
+
+func addMod(a, b, m uint64) uint64 {
+	sum, carry := bits.Add64(a, b, 0)
+
+	if carry != 0 || sum >= m {
+		sum -= m
+	}
+
+	return sum
+}
+
+func gcd(a, b uint64) uint64 {
+	for b != 0 {
+		a, b = b, a%b
+	}
+
+	return a
+}
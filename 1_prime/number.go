@@ -0,0 +1,82 @@
+package prime
+
+import (
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// maxExponent bounds the decimal exponent accepted in a number so that a
+// request like 1e999999999 can't make us allocate a gigantic integer.
+const maxExponent = 10000
+
+var errExponentTooLarge = errors.New("number exponent too large")
+
+// DecimalInt is a JSON number of arbitrary size and precision. Integers are
+// kept exactly; anything with a fractional part is reported by IsInt.
+type DecimalInt struct {
+	raw string
+	n   *big.Rat
+}
+
+func (di DecimalInt) IsInt() bool {
+	return di.n == nil || di.n.IsInt()
+}
+
+// Int returns the integer value of di. It is only meaningful when IsInt is
+// true.
+func (di DecimalInt) Int() *big.Int {
+	if di.n == nil {
+		return new(big.Int)
+	}
+
+	return new(big.Int).Set(di.n.Num())
+}
+
+func (di DecimalInt) String() string {
+	if di.raw == "" {
+		return "0"
+	}
+
+	return di.raw
+}
+
+func (di DecimalInt) MarshalJSON() ([]byte, error) {
+	return []byte(di.String()), nil
+}
+
+func (di *DecimalInt) UnmarshalJSON(input []byte) error {
+	raw := string(input)
+
+	if i := strings.IndexAny(raw, "eE"); i >= 0 {
+		exp, err := strconv.Atoi(raw[i+1:])
+
+		if err != nil {
+			return err
+		}
+
+		if exp > maxExponent || exp < -maxExponent {
+			return errExponentTooLarge
+		}
+	}
+
+	n, ok := new(big.Rat).SetString(raw)
+
+	if !ok {
+		return errors.New("invalid number: " + raw)
+	}
+
+	di.raw = raw
+	di.n = n
+
+	return nil
+}
+
+func isPrime(p *primeChecker, n *DecimalInt) bool {
+	if !n.IsInt() {
+		return false
+	}
+
+	return p.isPrime(n.Int())
+}
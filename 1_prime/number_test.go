@@ -0,0 +1,55 @@
+package prime
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsPrime(t *testing.T) {
+	tests := []struct {
+		name   string
+		number string
+		want   bool
+	}{
+		{"small prime", "7", true},
+		{"small composite", "8", false},
+		{"one", "1", false},
+		{"zero", "0", false},
+		{"negative", "-7", false},
+		{"non-integer", "7.5", false},
+		{"integer written as float", "7.0", true},
+		{"exponent", "1.3e1", true},
+		{"mersenne prime beyond int64", "170141183460469231731687303715884105727", true},
+		{"composite beyond int64", "170141183460469231731687303715884105729", false},
+		{"beyond float64 precision", "9007199254740993", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var n DecimalInt
+			require.NoError(t, json.Unmarshal([]byte(tt.number), &n))
+
+			assert.Equal(t, tt.want, isPrime(nil, &n))
+			assert.Equal(t, tt.want, isPrime(newPrimeChecker(100, 10), &n))
+		})
+	}
+}
+
+func TestDecimalIntUnmarshal(t *testing.T) {
+	t.Run("round trips the original text", func(t *testing.T) {
+		var n DecimalInt
+		require.NoError(t, json.Unmarshal([]byte("123456789012345678901234567890"), &n))
+
+		data, err := json.Marshal(n)
+		require.NoError(t, err)
+		assert.Equal(t, "123456789012345678901234567890", string(data))
+	})
+
+	t.Run("rejects huge exponents", func(t *testing.T) {
+		var n DecimalInt
+		assert.Error(t, json.Unmarshal([]byte("1e999999999"), &n))
+	})
+}
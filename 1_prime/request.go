@@ -0,0 +1,169 @@
+package prime
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	ErrMalformedJSON    = errors.New("request is not a JSON object")
+	ErrMissingField     = errors.New("request is missing a required field")
+	ErrInvalidMethod    = errors.New("request method is not supported")
+	ErrInvalidFieldType = errors.New("request field has the wrong type")
+
+	// ErrInvalidArgument is returned for well-formed requests whose values
+	// are outside what the method accepts. Unlike the errors above it is
+	// answered with an error response and the connection stays open.
+	ErrInvalidArgument = errors.New("invalid argument")
+)
+
+// malformedResponse is sent before disconnecting a client whose request
+// failed validation.
+var malformedResponse = []byte(`{"error":"malformed request"}` + "\n")
+
+type request struct {
+	Method string
+
+	fields map[string]json.RawMessage
+	primes *primeChecker
+}
+
+type errorResponse struct {
+	Method string `json:"method"`
+	Error  string `json:"error"`
+}
+
+// parseRequest strictly validates the envelope of a single request line: it
+// must be a JSON object whose method is the name of a registered method.
+// The method's own fields are validated when the request is called. Unknown
+// fields are ignored.
+func parseRequest(data []byte) (*request, error) {
+	var fields map[string]json.RawMessage
+
+	if err := json.Unmarshal(data, &fields); err != nil || fields == nil {
+		return nil, ErrMalformedJSON
+	}
+
+	rawMethod, ok := fields["method"]
+	if !ok {
+		return nil, fmt.Errorf("%w: method", ErrMissingField)
+	}
+
+	req := &request{fields: fields}
+
+	if !isJSONString(rawMethod) || json.Unmarshal(rawMethod, &req.Method) != nil {
+		return nil, fmt.Errorf("%w: method must be a string", ErrInvalidFieldType)
+	}
+
+	if _, ok := methods[req.Method]; !ok {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidMethod, req.Method)
+	}
+
+	return req, nil
+}
+
+// call runs the request's method and returns the response to encode.
+func (r *request) call() (any, error) {
+	return methods[r.Method].call(r)
+}
+
+func (r *request) has(name string) bool {
+	_, ok := r.fields[name]
+	return ok
+}
+
+// number decodes a required numeric field. Numbers sent as strings,
+// booleans or null are rejected.
+func (r *request) number(name string) (*DecimalInt, error) {
+	raw, ok := r.fields[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMissingField, name)
+	}
+
+	return decodeNumber(name, raw)
+}
+
+// integer decodes a required numeric field that must hold an integer of at
+// most maxBits bits.
+func (r *request) integer(name string, maxBits int) (*big.Int, error) {
+	n, err := r.number(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return toInteger(name, n, maxBits)
+}
+
+// integers decodes a required array of integers of at most maxBits bits.
+func (r *request) integers(name string, maxLen, maxBits int) ([]*big.Int, error) {
+	raw, ok := r.fields[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrMissingField, name)
+	}
+
+	var elems []json.RawMessage
+
+	if len(raw) == 0 || raw[0] != '[' || json.Unmarshal(raw, &elems) != nil {
+		return nil, fmt.Errorf("%w: %s must be an array", ErrInvalidFieldType, name)
+	}
+
+	if len(elems) > maxLen {
+		return nil, fmt.Errorf("%w: %s holds more than %d numbers", ErrInvalidArgument, name, maxLen)
+	}
+
+	result := make([]*big.Int, 0, len(elems))
+
+	for _, elem := range elems {
+		n, err := decodeNumber(name, elem)
+		if err != nil {
+			return nil, err
+		}
+
+		i, err := toInteger(name, n, maxBits)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, i)
+	}
+
+	return result, nil
+}
+
+func decodeNumber(name string, raw json.RawMessage) (*DecimalInt, error) {
+	if !isJSONNumber(raw) {
+		return nil, fmt.Errorf("%w: %s must be a number", ErrInvalidFieldType, name)
+	}
+
+	n := &DecimalInt{}
+
+	if err := json.Unmarshal(raw, n); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidFieldType, err.Error())
+	}
+
+	return n, nil
+}
+
+func toInteger(name string, n *DecimalInt, maxBits int) (*big.Int, error) {
+	if !n.IsInt() {
+		return nil, fmt.Errorf("%w: %s must be an integer", ErrInvalidArgument, name)
+	}
+
+	i := n.Int()
+
+	if i.BitLen() > maxBits {
+		return nil, fmt.Errorf("%w: %s exceeds %d bits", ErrInvalidArgument, name, maxBits)
+	}
+
+	return i, nil
+}
+
+func isJSONString(raw json.RawMessage) bool {
+	return len(raw) > 0 && raw[0] == '"'
+}
+
+func isJSONNumber(raw json.RawMessage) bool {
+	return len(raw) > 0 && (raw[0] == '-' || (raw[0] >= '0' && raw[0] <= '9'))
+}
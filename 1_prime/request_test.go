@@ -0,0 +1,151 @@
+package prime
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/bcatubig/protohackers/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func call(data string) (any, error) {
+	req, err := parseRequest([]byte(data + "\n"))
+	if err != nil {
+		return nil, err
+	}
+
+	return req.call()
+}
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		wantErr error
+	}{
+		{"valid", `{"method":"isPrime","number":7}`, nil},
+		{"valid with extra fields", `{"method":"isPrime","number":7,"extra":true}`, nil},
+		{"valid float", `{"method":"isPrime","number":7.5}`, nil},
+		{"not json", `{"method":"isPrime","number":7`, ErrMalformedJSON},
+		{"array", `[1,2,3]`, ErrMalformedJSON},
+		{"bare number", `7`, ErrMalformedJSON},
+		{"null", `null`, ErrMalformedJSON},
+		{"trailing data", `{"method":"isPrime","number":7} x`, ErrMalformedJSON},
+		{"missing method", `{"number":7}`, ErrMissingField},
+		{"missing number", `{"method":"isPrime"}`, ErrMissingField},
+		{"unknown method", `{"method":"isEven","number":7}`, ErrInvalidMethod},
+		{"method not a string", `{"method":1,"number":7}`, ErrInvalidFieldType},
+		{"method null", `{"method":null,"number":7}`, ErrInvalidFieldType},
+		{"number as string", `{"method":"isPrime","number":"7"}`, ErrInvalidFieldType},
+		{"number as bool", `{"method":"isPrime","number":true}`, ErrInvalidFieldType},
+		{"number null", `{"method":"isPrime","number":null}`, ErrInvalidFieldType},
+		{"number as object", `{"method":"isPrime","number":{}}`, ErrInvalidFieldType},
+		{"gcd numbers not an array", `{"method":"gcd","numbers":7}`, ErrInvalidFieldType},
+		{"gcd number as string", `{"method":"gcd","numbers":[4,"6"]}`, ErrInvalidFieldType},
+		{"range missing max", `{"method":"primesInRange","min":1}`, ErrMissingField},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := call(tt.data)
+
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestMethods(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    string
+		wantErr error
+	}{
+		{"isPrime", `{"method":"isPrime","number":13}`, `{"method":"isPrime","prime":true}`, nil},
+		{"isProbablePrime default confidence", `{"method":"isProbablePrime","number":13}`, `{"method":"isProbablePrime","prime":true,"confidence":20}`, nil},
+		{"isProbablePrime confidence", `{"method":"isProbablePrime","number":15,"confidence":5}`, `{"method":"isProbablePrime","prime":false,"confidence":5}`, nil},
+		{"isProbablePrime non-integer", `{"method":"isProbablePrime","number":13.5}`, `{"method":"isProbablePrime","prime":false,"confidence":20}`, nil},
+		{"isProbablePrime too large", `{"method":"isProbablePrime","number":1e1300}`, "", ErrInvalidArgument},
+		{"isProbablePrime confidence too high", `{"method":"isProbablePrime","number":13,"confidence":1000}`, "", ErrInvalidArgument},
+		{"factorize", `{"method":"factorize","number":360}`, `{"method":"factorize","number":360,"factors":[2,2,2,3,3,5]}`, nil},
+		{"factorize one", `{"method":"factorize","number":1}`, `{"method":"factorize","number":1,"factors":[]}`, nil},
+		{"factorize semiprime", `{"method":"factorize","number":18446744030759878681}`, `{"method":"factorize","number":18446744030759878681,"factors":[4294967291,4294967291]}`, nil},
+		{"factorize too large", `{"method":"factorize","number":18446744073709551616}`, "", ErrInvalidArgument},
+		{"factorize non-integer", `{"method":"factorize","number":1.5}`, "", ErrInvalidArgument},
+		{"factorize negative", `{"method":"factorize","number":-4}`, "", ErrInvalidArgument},
+		{"nextPrime", `{"method":"nextPrime","number":13}`, `{"method":"nextPrime","prime":17}`, nil},
+		{"nextPrime negative", `{"method":"nextPrime","number":-13}`, `{"method":"nextPrime","prime":2}`, nil},
+		{"nextPrime two", `{"method":"nextPrime","number":2}`, `{"method":"nextPrime","prime":3}`, nil},
+		{"primesInRange", `{"method":"primesInRange","min":10,"max":30}`, `{"method":"primesInRange","primes":[11,13,17,19,23,29]}`, nil},
+		{"primesInRange empty", `{"method":"primesInRange","min":24,"max":28}`, `{"method":"primesInRange","primes":[]}`, nil},
+		{"primesInRange inverted", `{"method":"primesInRange","min":30,"max":10}`, "", ErrInvalidArgument},
+		{"primesInRange too wide", `{"method":"primesInRange","min":0,"max":1000000}`, "", ErrInvalidArgument},
+		{"gcd", `{"method":"gcd","numbers":[12,-18,30]}`, `{"method":"gcd","gcd":6}`, nil},
+		{"gcd beyond int64", `{"method":"gcd","numbers":[36893488147419103232,18446744073709551616]}`, `{"method":"gcd","gcd":18446744073709551616}`, nil},
+		{"gcd single number", `{"method":"gcd","numbers":[12]}`, "", ErrInvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := call(tt.data)
+
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			require.NoError(t, err)
+
+			got, err := json.Marshal(resp)
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestHandle(t *testing.T) {
+	s := &Server{
+		logger:   slog.New(slog.DiscardHandler),
+		requests: metrics.NewRegistry().Requests("prime"),
+	}
+
+	client, srv := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		s.handle(context.Background(), srv)
+		srv.Close()
+	}()
+
+	go io.WriteString(client, `{"method":"factorize","number":1.5}`+"\n"+
+		`{"method":"isPrime","number":7}`+"\n"+
+		`{"method":"isPrime","number":"7"}`+"\n")
+
+	reader := bufio.NewReader(client)
+
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, `{"method":"factorize","error":"invalid argument: number must be an integer"}`+"\n", line)
+
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, `{"method":"isPrime","prime":true}`+"\n", line)
+
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, string(malformedResponse), line)
+
+	_, err = reader.ReadString('\n')
+	assert.ErrorIs(t, err, io.EOF)
+}
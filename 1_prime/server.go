@@ -0,0 +1,268 @@
+package prime
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/bcatubig/protohackers/metrics"
+	"github.com/bcatubig/protohackers/server"
+)
+
+const defaultMaxInFlight = 1024
+
+type Server struct {
+	*server.Server
+
+	l net.Listener
+
+	logger   *slog.Logger
+	metrics  *metrics.Registry
+	requests *metrics.Requests
+
+	jsonrpc bool
+
+	sieveBound int
+	cacheSize  int
+	primes     *primeChecker
+
+	workers     int
+	maxInFlight int
+}
+
+type ServerOpt func(s *Server)
+
+func WithListener(l net.Listener) ServerOpt {
+	return func(s *Server) {
+		s.l = l
+	}
+}
+
+func WithLogger(l *slog.Logger) ServerOpt {
+	return func(s *Server) {
+		s.logger = l
+	}
+}
+
+func WithMetrics(r *metrics.Registry) ServerOpt {
+	return func(s *Server) {
+		s.metrics = r
+	}
+}
+
+// WithPrimeCache sets the bound below which primality is answered from a
+// precomputed sieve and the number of other results kept in an LRU cache.
+// Zero disables either.
+func WithPrimeCache(sieveBound, cacheSize int) ServerOpt {
+	return func(s *Server) {
+		s.sieveBound = sieveBound
+		s.cacheSize = cacheSize
+	}
+}
+
+// WithPipelining sets how many requests from a single connection are
+// evaluated concurrently and how many may be queued, evaluated or waiting to
+// be written, before the server stops reading from that connection.
+func WithPipelining(workers, maxInFlight int) ServerOpt {
+	return func(s *Server) {
+		s.workers = workers
+		s.maxInFlight = maxInFlight
+	}
+}
+
+// WithJSONRPC accepts JSON-RPC 2.0 requests and batches alongside the
+// original protocol. The format is detected per line.
+func WithJSONRPC() ServerOpt {
+	return func(s *Server) {
+		s.jsonrpc = true
+	}
+}
+
+func NewServer(addr string, opts ...ServerOpt) (*Server, error) {
+	s := &Server{
+		sieveBound:  defaultSieveBound,
+		cacheSize:   defaultCacheSize,
+		workers:     runtime.GOMAXPROCS(0),
+		maxInFlight: defaultMaxInFlight,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.logger == nil {
+		s.logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+	}
+
+	if s.metrics == nil {
+		s.metrics = metrics.NewRegistry()
+	}
+
+	s.requests = s.metrics.Requests("prime")
+	s.primes = newPrimeChecker(s.sieveBound, s.cacheSize)
+
+	srv, err := server.New(addr, server.HandlerFunc(s.handle), server.WithListener(s.l), server.WithLogger(s.logger), server.WithMetrics(s.metrics, "prime"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.Server = srv
+
+	return s, nil
+}
+
+func (s *Server) handle(ctx context.Context, c net.Conn) {
+	ip := c.RemoteAddr().String()
+	reader := bufio.NewReader(c)
+
+	// Requests are evaluated by a pool of workers and answered in the order
+	// they arrived: pending holds jobs in arrival order and bounds how many
+	// may be in flight before we stop reading from the client.
+	work := make(chan *job)
+	pending := make(chan *job, s.maxInFlight)
+	chanDone := make(chan struct{})
+
+	wg := &sync.WaitGroup{}
+
+	for range max(s.workers, 1) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for j := range work {
+				j.result <- s.process(j.data, ip)
+			}
+		}()
+	}
+
+	chanWriterDone := make(chan struct{})
+
+	go func() {
+		defer close(chanWriterDone)
+		s.writeResponses(c, pending, chanDone)
+	}()
+
+	defer func() {
+		close(work)
+		close(pending)
+		wg.Wait()
+		<-chanWriterDone
+	}()
+
+	for {
+		data, err := reader.ReadBytes('\n')
+
+		if err != nil {
+			return
+		}
+
+		j := &job{data: data, result: make(chan result, 1)}
+
+		select {
+		case work <- j:
+		case <-chanDone:
+			return
+		}
+
+		select {
+		case pending <- j:
+		case <-chanDone:
+			return
+		}
+	}
+}
+
+type job struct {
+	data   []byte
+	result chan result
+}
+
+type result struct {
+	out       []byte
+	closeConn bool
+}
+
+// writeResponses writes each pending job's response in order, flushing
+// whenever it catches up with the workers. It closes chanDone and the
+// connection if it stops early because a request was malformed or a write
+// failed.
+func (s *Server) writeResponses(c net.Conn, pending <-chan *job, chanDone chan struct{}) {
+	defer close(chanDone)
+
+	w := bufio.NewWriter(c)
+
+	for j := range pending {
+		r := <-j.result
+
+		w.Write(r.out)
+
+		if r.closeConn {
+			w.Flush()
+			c.Close()
+			return
+		}
+
+		if len(pending) > 0 {
+			continue
+		}
+
+		if err := w.Flush(); err != nil {
+			s.logger.Error("error writing response", "error", err.Error())
+			c.Close()
+			return
+		}
+	}
+
+	w.Flush()
+}
+
+// process evaluates a single request line and returns the bytes to send
+// back, if any, and whether the connection must be closed afterwards.
+func (s *Server) process(data []byte, ip string) result {
+	start := time.Now()
+
+	s.logger.Info("request received", "data", string(data))
+
+	if s.jsonrpc && isJSONRPC(data) {
+		return result{out: s.handleRPC(data)}
+	}
+
+	req, err := parseRequest(data)
+
+	var resp any
+
+	if err == nil {
+		req.primes = s.primes
+		resp, err = req.call()
+	}
+
+	if errors.Is(err, ErrInvalidArgument) {
+		s.logger.Error("invalid argument", "method", req.Method, "error", err.Error(), "ip", ip)
+		resp = &errorResponse{Method: req.Method, Error: err.Error()}
+	} else if err != nil {
+		s.logger.Error("malformed request", "error", err.Error(), "ip", ip)
+		s.requests.Malformed()
+		return result{out: malformedResponse, closeConn: true}
+	}
+
+	s.logger.Info("request handled", "method", req.Method, "response", resp)
+
+	out, err := json.Marshal(resp)
+	if err != nil {
+		s.logger.Error("error encoding response", "error", err.Error(), "ip", ip)
+		return result{out: malformedResponse, closeConn: true}
+	}
+
+	s.requests.Observe(req.Method, start)
+
+	return result{out: append(out, '\n')}
+}
@@ -0,0 +1,56 @@
+package prime
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/bcatubig/protohackers/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandlePipelined(t *testing.T) {
+	s := &Server{
+		logger:      slog.New(slog.DiscardHandler),
+		requests:    metrics.NewRegistry().Requests("prime"),
+		primes:      newPrimeChecker(100, 100),
+		workers:     8,
+		maxInFlight: 16,
+	}
+
+	client, srv := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		s.handle(context.Background(), srv)
+		srv.Close()
+	}()
+
+	const count = 2000
+
+	go func() {
+		sb := &strings.Builder{}
+
+		for i := range count {
+			fmt.Fprintf(sb, `{"method":"isPrime","number":%d}`+"\n", i)
+		}
+
+		client.Write([]byte(sb.String()))
+	}()
+
+	reader := bufio.NewReader(client)
+
+	for i := range count {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+
+		want := fmt.Sprintf(`{"method":"isPrime","prime":%t}`+"\n", big.NewInt(int64(i)).ProbablyPrime(0))
+		assert.Equal(t, want, line, "response %d", i)
+	}
+}
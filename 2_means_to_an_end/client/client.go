@@ -0,0 +1,173 @@
+// Package client talks to a Means to an End server.
+package client
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+
+	"github.com/bcatubig/protohackers/2_means_to_an_end/codec"
+)
+
+// Client sends messages over a single connection. Inserts are buffered and
+// sent with the next query, Flush or Close, so bulk loads don't cost a write
+// per price. A Client is safe for concurrent use.
+type Client struct {
+	mu   sync.Mutex
+	conn net.Conn
+	buf  *bufio.Writer
+	r    *codec.Reader
+	w    *codec.Writer
+}
+
+// Dial connects to the server at addr.
+func Dial(addr string) (*Client, error) {
+	return DialContext(context.Background(), addr)
+}
+
+func DialContext(ctx context.Context, addr string) (*Client, error) {
+	var d net.Dialer
+
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(conn), nil
+}
+
+// New returns a Client that talks over conn.
+func New(conn net.Conn) *Client {
+	buf := bufio.NewWriter(conn)
+
+	return &Client{
+		conn: conn,
+		buf:  buf,
+		r:    codec.NewReader(conn),
+		w:    codec.NewWriter(buf),
+	}
+}
+
+// Session attaches the connection to a durable session on a server started
+// with persistence. It must be called before anything else.
+func (c *Client) Session(id [8]byte) error {
+	return c.send(codec.Session{ID: id})
+}
+
+// Namespace attaches the connection to a namespace shared with other clients
+// on a server started with namespaces. It must be called before anything
+// else.
+func (c *Client) Namespace(name string) error {
+	n, err := codec.NewNamespace(name)
+	if err != nil {
+		return err
+	}
+
+	return c.send(n)
+}
+
+// Insert stores a price at timestamp.
+func (c *Client) Insert(timestamp, cents int32) error {
+	return c.send(codec.Insert{Timestamp: timestamp, Price: cents})
+}
+
+// Query returns the mean price between minTime and maxTime inclusive.
+func (c *Client) Query(minTime, maxTime int32) (int32, error) {
+	return c.int32(codec.Query{MinTime: minTime, MaxTime: maxTime})
+}
+
+func (c *Client) Min(minTime, maxTime int32) (int32, error) {
+	return c.int32(codec.Aggregate{Kind: codec.OpMin, MinTime: minTime, MaxTime: maxTime})
+}
+
+func (c *Client) Max(minTime, maxTime int32) (int32, error) {
+	return c.int32(codec.Aggregate{Kind: codec.OpMax, MinTime: minTime, MaxTime: maxTime})
+}
+
+func (c *Client) Median(minTime, maxTime int32) (int32, error) {
+	return c.int32(codec.Aggregate{Kind: codec.OpMedian, MinTime: minTime, MaxTime: maxTime})
+}
+
+func (c *Client) Count(minTime, maxTime int32) (int32, error) {
+	return c.int32(codec.Aggregate{Kind: codec.OpCount, MinTime: minTime, MaxTime: maxTime})
+}
+
+func (c *Client) Sum(minTime, maxTime int32) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.request(codec.Aggregate{Kind: codec.OpSum, MinTime: minTime, MaxTime: maxTime}); err != nil {
+		return 0, err
+	}
+
+	return c.r.ReadInt64()
+}
+
+// Percentile returns the nth percentile price between minTime and maxTime
+// inclusive.
+func (c *Client) Percentile(minTime, maxTime, n int32) (int32, error) {
+	return c.int32(codec.Percentile{MinTime: minTime, MaxTime: maxTime, N: n})
+}
+
+// OHLC returns a candle for every bucket of width seconds between minTime
+// and maxTime inclusive that holds a price.
+func (c *Client) OHLC(minTime, maxTime, width int32) ([]codec.Candle, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.request(codec.OHLC{MinTime: minTime, MaxTime: maxTime, Width: width}); err != nil {
+		return nil, err
+	}
+
+	return c.r.ReadCandles()
+}
+
+// Flush sends any buffered inserts.
+func (c *Client) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.buf.Flush()
+}
+
+// Close flushes buffered inserts and closes the connection.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := c.buf.Flush()
+
+	if closeErr := c.conn.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func (c *Client) send(m codec.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.w.WriteMessage(m)
+}
+
+func (c *Client) int32(m codec.Message) (int32, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.request(m); err != nil {
+		return 0, err
+	}
+
+	return c.r.ReadInt32()
+}
+
+// request writes m and flushes so its answer can be read. c.mu must be held.
+func (c *Client) request(m codec.Message) error {
+	if err := c.w.WriteMessage(m); err != nil {
+		return err
+	}
+
+	return c.buf.Flush()
+}
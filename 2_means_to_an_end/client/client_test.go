@@ -0,0 +1,61 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+	"time"
+
+	means "github.com/bcatubig/protohackers/2_means_to_an_end"
+	"github.com/bcatubig/protohackers/2_means_to_an_end/codec"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startServer(t *testing.T) string {
+	t.Helper()
+
+	srv, err := means.NewServer("127.0.0.1:0", means.WithLogger(slog.New(slog.DiscardHandler)))
+	require.NoError(t, err)
+
+	go srv.ListenAndServe()
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		srv.Shutdown(ctx)
+	})
+
+	return srv.Addr().String()
+}
+
+func TestClient(t *testing.T) {
+	c, err := Dial(startServer(t))
+	require.NoError(t, err)
+	defer c.Close()
+
+	require.NoError(t, c.Insert(12345, 101))
+	require.NoError(t, c.Insert(12346, 102))
+	require.NoError(t, c.Insert(12347, 100))
+	require.NoError(t, c.Insert(40960, 5))
+
+	mean, err := c.Query(12288, 16384)
+	require.NoError(t, err)
+	assert.Equal(t, int32(101), mean)
+
+	sum, err := c.Sum(12288, 16384)
+	require.NoError(t, err)
+	assert.Equal(t, int64(303), sum)
+
+	maxPrice, err := c.Max(0, 50000)
+	require.NoError(t, err)
+	assert.Equal(t, int32(102), maxPrice)
+
+	candles, err := c.OHLC(12345, 12347, 2)
+	require.NoError(t, err)
+	assert.Equal(t, []codec.Candle{
+		{Start: 12345, Open: 101, High: 102, Low: 101, Close: 102},
+		{Start: 12347, Open: 100, High: 100, Low: 100, Close: 100},
+	}, candles)
+}
@@ -0,0 +1,262 @@
+// Package codec encodes and decodes the Means to an End binary protocol.
+//
+// Every message is a one byte opcode followed by big-endian int32 fields,
+// or by an 8 byte id for Session and Namespace. Most messages carry two
+// fields, making them 9 bytes long; Percentile and OHLC carry a third and are
+// 13 bytes long.
+package codec
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Op is a message's opcode.
+type Op byte
+
+const (
+	OpInsert     Op = 'I'
+	OpQuery      Op = 'Q'
+	OpSession    Op = 'S'
+	OpNamespace  Op = 'N'
+	OpMin        Op = 'L'
+	OpMax        Op = 'H'
+	OpMedian     Op = 'M'
+	OpSum        Op = 'U'
+	OpCount      Op = 'C'
+	OpPercentile Op = 'P'
+	OpOHLC       Op = 'O'
+)
+
+// Size is the length of a message with two fields, which is every message
+// but Percentile and OHLC.
+const Size = 9
+
+// Size returns the encoded length of a message with opcode o, or 0 if o is
+// unknown.
+func (o Op) Size() int {
+	switch o {
+	case OpInsert, OpQuery, OpSession, OpNamespace, OpMin, OpMax, OpMedian, OpSum, OpCount:
+		return Size
+	case OpPercentile, OpOHLC:
+		return Size + 4
+	default:
+		return 0
+	}
+}
+
+func (o Op) String() string {
+	return string(rune(o))
+}
+
+// ShortReadError is returned when a message ends before all of its fields
+// were read.
+type ShortReadError struct {
+	Op   Op
+	Want int
+	Got  int
+}
+
+func (e *ShortReadError) Error() string {
+	return fmt.Sprintf("short %s message: want %d bytes, got %d", e.Op, e.Want, e.Got)
+}
+
+func (e *ShortReadError) Unwrap() error {
+	return io.ErrUnexpectedEOF
+}
+
+// UnknownOpError is returned for a message with an opcode this package
+// doesn't know.
+type UnknownOpError struct {
+	Op byte
+}
+
+func (e *UnknownOpError) Error() string {
+	return fmt.Sprintf("unknown opcode %q", e.Op)
+}
+
+// Message is one of the message types below.
+type Message interface {
+	Op() Op
+}
+
+// Insert stores Price at Timestamp.
+type Insert struct {
+	Timestamp int32
+	Price     int32
+}
+
+// Query asks for the mean price between MinTime and MaxTime inclusive.
+type Query struct {
+	MinTime int32
+	MaxTime int32
+}
+
+// Session attaches the connection to a durable session. It must be the first
+// message on a connection.
+type Session struct {
+	ID [8]byte
+}
+
+// Namespace attaches the connection to a namespace shared with every other
+// connection that names it. It must be the first message on a connection.
+type Namespace struct {
+	Name [8]byte
+}
+
+// NewNamespace returns a Namespace for name, which is at most 8 bytes long.
+func NewNamespace(name string) (Namespace, error) {
+	var n Namespace
+
+	if len(name) == 0 || len(name) > len(n.Name) {
+		return n, fmt.Errorf("namespace %q must be 1 to %d bytes", name, len(n.Name))
+	}
+
+	copy(n.Name[:], name)
+
+	return n, nil
+}
+
+// String returns the name without its zero padding.
+func (n Namespace) String() string {
+	return strings.TrimRight(string(n.Name[:]), "\x00")
+}
+
+// Aggregate asks for the min, max, median, sum or count of the prices
+// between MinTime and MaxTime inclusive, as chosen by Kind.
+type Aggregate struct {
+	Kind    Op
+	MinTime int32
+	MaxTime int32
+}
+
+// Percentile asks for the Nth percentile of the prices between MinTime and
+// MaxTime inclusive.
+type Percentile struct {
+	MinTime int32
+	MaxTime int32
+	N       int32
+}
+
+// OHLC asks for candles of Width seconds between MinTime and MaxTime
+// inclusive.
+type OHLC struct {
+	MinTime int32
+	MaxTime int32
+	Width   int32
+}
+
+func (Insert) Op() Op      { return OpInsert }
+func (Query) Op() Op       { return OpQuery }
+func (Session) Op() Op     { return OpSession }
+func (Namespace) Op() Op   { return OpNamespace }
+func (a Aggregate) Op() Op { return a.Kind }
+func (Percentile) Op() Op  { return OpPercentile }
+func (OHLC) Op() Op        { return OpOHLC }
+
+// Candle is the first, highest, lowest and last price in a time bucket
+// starting at Start, as sent in response to OHLC.
+type Candle struct {
+	Start int32
+	Open  int32
+	High  int32
+	Low   int32
+	Close int32
+}
+
+// CandleSize is the encoded length of a Candle.
+const CandleSize = 20
+
+// Encode returns the wire form of m.
+func Encode(m Message) ([]byte, error) {
+	out := make([]byte, 1, m.Op().Size())
+	out[0] = byte(m.Op())
+
+	switch m := m.(type) {
+	case Insert:
+		out = appendInt32s(out, m.Timestamp, m.Price)
+	case Query:
+		out = appendInt32s(out, m.MinTime, m.MaxTime)
+	case Session:
+		out = append(out, m.ID[:]...)
+	case Namespace:
+		out = append(out, m.Name[:]...)
+	case Aggregate:
+		switch m.Kind {
+		case OpMin, OpMax, OpMedian, OpSum, OpCount:
+		default:
+			return nil, &UnknownOpError{Op: byte(m.Kind)}
+		}
+
+		out = appendInt32s(out, m.MinTime, m.MaxTime)
+	case Percentile:
+		out = appendInt32s(out, m.MinTime, m.MaxTime, m.N)
+	case OHLC:
+		out = appendInt32s(out, m.MinTime, m.MaxTime, m.Width)
+	default:
+		return nil, &UnknownOpError{Op: byte(m.Op())}
+	}
+
+	return out, nil
+}
+
+// Decode reads the message at the start of data and returns it along with
+// the number of bytes it took up. For an unknown opcode it returns an
+// *UnknownOpError and Size, since the protocol frames every message it
+// doesn't define as 9 bytes.
+func Decode(data []byte) (Message, int, error) {
+	if len(data) == 0 {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	op := Op(data[0])
+	size := op.Size()
+
+	if size == 0 {
+		return nil, Size, &UnknownOpError{Op: data[0]}
+	}
+
+	if len(data) < size {
+		return nil, 0, &ShortReadError{Op: op, Want: size, Got: len(data)}
+	}
+
+	return decodeBody(op, data[1:size]), size, nil
+}
+
+// decodeBody builds the message for a known op from its fields.
+func decodeBody(op Op, body []byte) Message {
+	field := func(i int) int32 {
+		return int32(binary.BigEndian.Uint32(body[i*4:]))
+	}
+
+	switch op {
+	case OpInsert:
+		return Insert{Timestamp: field(0), Price: field(1)}
+	case OpQuery:
+		return Query{MinTime: field(0), MaxTime: field(1)}
+	case OpSession:
+		var s Session
+		copy(s.ID[:], body)
+		return s
+	case OpNamespace:
+		var n Namespace
+		copy(n.Name[:], body)
+		return n
+	case OpPercentile:
+		return Percentile{MinTime: field(0), MaxTime: field(1), N: field(2)}
+	case OpOHLC:
+		return OHLC{MinTime: field(0), MaxTime: field(1), Width: field(2)}
+	default:
+		return Aggregate{Kind: op, MinTime: field(0), MaxTime: field(1)}
+	}
+}
+
+func appendInt32s(out []byte, nums ...int32) []byte {
+	for _, n := range nums {
+		out = binary.BigEndian.AppendUint32(out, uint32(n))
+	}
+
+	return out
+}
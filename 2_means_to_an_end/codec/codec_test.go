@@ -0,0 +1,139 @@
+package codec
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+		want []byte
+	}{
+		{"insert", Insert{Timestamp: 12345, Price: 101}, []byte{'I', 0, 0, 0x30, 0x39, 0, 0, 0, 0x65}},
+		{"query", Query{MinTime: 1000, MaxTime: 100000}, []byte{'Q', 0, 0, 0x03, 0xe8, 0, 0x01, 0x86, 0xa0}},
+		{"negative", Insert{Timestamp: -1, Price: -2}, []byte{'I', 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe}},
+		{"session", Session{ID: [8]byte{1, 2, 3, 4, 5, 6, 7, 8}}, []byte{'S', 1, 2, 3, 4, 5, 6, 7, 8}},
+		{"namespace", Namespace{Name: [8]byte{'b', 't', 'c'}}, []byte{'N', 'b', 't', 'c', 0, 0, 0, 0, 0}},
+		{"min", Aggregate{Kind: OpMin, MinTime: 1, MaxTime: 2}, []byte{'L', 0, 0, 0, 1, 0, 0, 0, 2}},
+		{"percentile", Percentile{MinTime: 1, MaxTime: 2, N: 90}, []byte{'P', 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 90}},
+		{"ohlc", OHLC{MinTime: 1, MaxTime: 2, Width: 60}, []byte{'O', 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 60}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Encode(tt.msg)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+
+			msg, n, err := Decode(append(got, 'x'))
+			require.NoError(t, err)
+			assert.Equal(t, len(tt.want), n)
+			assert.Equal(t, tt.msg, msg)
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	t.Run("short", func(t *testing.T) {
+		_, _, err := Decode([]byte{'P', 0, 0, 0, 1, 0, 0, 0, 2})
+
+		var short *ShortReadError
+		require.ErrorAs(t, err, &short)
+		assert.Equal(t, &ShortReadError{Op: OpPercentile, Want: 13, Got: 9}, short)
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("unknown opcode", func(t *testing.T) {
+		_, n, err := Decode([]byte{'Z', 0, 0, 0, 0, 0, 0, 0, 0})
+
+		var unknown *UnknownOpError
+		require.ErrorAs(t, err, &unknown)
+		assert.Equal(t, byte('Z'), unknown.Op)
+		assert.Equal(t, Size, n)
+	})
+
+	t.Run("unknown aggregate", func(t *testing.T) {
+		_, err := Encode(Aggregate{Kind: OpInsert})
+
+		var unknown *UnknownOpError
+		assert.ErrorAs(t, err, &unknown)
+	})
+}
+
+func TestReader(t *testing.T) {
+	var buf bytes.Buffer
+
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteMessage(Insert{Timestamp: 1, Price: 2}))
+	buf.Write([]byte{'Z', 0, 0, 0, 0, 0, 0, 0, 0})
+	require.NoError(t, w.WriteMessage(OHLC{MinTime: 1, MaxTime: 2, Width: 3}))
+	buf.Write([]byte{'Q', 0, 0})
+
+	r := NewReader(&buf)
+
+	msg, err := r.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, Insert{Timestamp: 1, Price: 2}, msg)
+
+	_, err = r.ReadMessage()
+	var unknown *UnknownOpError
+	require.ErrorAs(t, err, &unknown)
+
+	msg, err = r.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, OHLC{MinTime: 1, MaxTime: 2, Width: 3}, msg)
+
+	_, err = r.ReadMessage()
+	assert.Equal(t, &ShortReadError{Op: OpQuery, Want: 9, Got: 3}, err)
+
+	_, err = NewReader(&buf).ReadMessage()
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestResponses(t *testing.T) {
+	var buf bytes.Buffer
+
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteInt32(-5))
+	require.NoError(t, w.WriteInt64(1<<40))
+	require.NoError(t, w.WriteCandles([]Candle{{Start: 1, Open: 2, High: 3, Low: 4, Close: 5}}))
+	require.NoError(t, w.WriteCandles(nil))
+
+	assert.Equal(t, 4+8+4+CandleSize+4, buf.Len())
+
+	r := NewReader(&buf)
+
+	i32, err := r.ReadInt32()
+	require.NoError(t, err)
+	assert.Equal(t, int32(-5), i32)
+
+	i64, err := r.ReadInt64()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1<<40), i64)
+
+	candles, err := r.ReadCandles()
+	require.NoError(t, err)
+	assert.Equal(t, []Candle{{Start: 1, Open: 2, High: 3, Low: 4, Close: 5}}, candles)
+
+	candles, err = r.ReadCandles()
+	require.NoError(t, err)
+	assert.Empty(t, candles)
+}
+
+func TestNamespace(t *testing.T) {
+	n, err := NewNamespace("btc")
+	require.NoError(t, err)
+	assert.Equal(t, "btc", n.String())
+
+	_, err = NewNamespace("toolongname")
+	assert.Error(t, err)
+
+	_, err = NewNamespace("")
+	assert.Error(t, err)
+}
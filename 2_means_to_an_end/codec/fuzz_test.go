@@ -0,0 +1,61 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func fuzzSeeds(f *testing.F) {
+	f.Add([]byte{'I', 0, 0, 0x30, 0x39, 0, 0, 0, 0x65})
+	f.Add([]byte{'Q', 0, 0, 0x03, 0xe8, 0, 0x01, 0x86, 0xa0, 'I', 0})
+	f.Add([]byte{'P', 0, 0, 0, 1, 0, 0, 0, 2, 0, 0, 0, 90})
+	f.Add([]byte{'Z', 1, 2})
+	f.Add([]byte{})
+}
+
+func FuzzDecode(f *testing.F) {
+	fuzzSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg, n, err := Decode(data)
+		if err != nil {
+			return
+		}
+
+		out, err := Encode(msg)
+		if err != nil {
+			t.Fatalf("encoding decoded %#v: %v", msg, err)
+		}
+
+		if !bytes.Equal(out, data[:n]) {
+			t.Fatalf("round trip of %x gave %x", data[:n], out)
+		}
+	})
+}
+
+func FuzzReader(f *testing.F) {
+	fuzzSeeds(f)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		r := NewReader(bytes.NewReader(data))
+
+		for range len(data) + 1 {
+			_, err := r.ReadMessage()
+
+			var unknown *UnknownOpError
+
+			switch {
+			case err == nil, errors.As(err, &unknown):
+				continue
+			case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+				return
+			default:
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+
+		t.Fatal("reader did not reach the end of the stream")
+	})
+}
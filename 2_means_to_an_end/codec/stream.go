@@ -0,0 +1,138 @@
+package codec
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Reader reads messages, or responses, from a stream.
+type Reader struct {
+	r *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// ReadMessage reads the next message. It returns io.EOF if the stream ends
+// cleanly between messages and a *ShortReadError if it ends inside one. An
+// unknown opcode is reported with an *UnknownOpError after skipping the rest
+// of its 9 bytes, so the stream can still be read from.
+func (r *Reader) ReadMessage() (Message, error) {
+	b, err := r.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+
+	op := Op(b)
+	size := op.Size()
+
+	if size == 0 {
+		if n, err := r.r.Discard(Size - 1); err != nil {
+			return nil, &ShortReadError{Op: op, Want: Size, Got: n + 1}
+		}
+
+		return nil, &UnknownOpError{Op: b}
+	}
+
+	body := make([]byte, size-1)
+
+	if n, err := io.ReadFull(r.r, body); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, &ShortReadError{Op: op, Want: size, Got: n + 1}
+		}
+
+		return nil, err
+	}
+
+	return decodeBody(op, body), nil
+}
+
+// ReadInt32 reads a 4 byte response.
+func (r *Reader) ReadInt32() (int32, error) {
+	var v int32
+
+	err := binary.Read(r.r, binary.BigEndian, &v)
+
+	return v, err
+}
+
+// ReadInt64 reads an 8 byte response, the answer to a sum.
+func (r *Reader) ReadInt64() (int64, error) {
+	var v int64
+
+	err := binary.Read(r.r, binary.BigEndian, &v)
+
+	return v, err
+}
+
+// ReadCandles reads the answer to an OHLC message: a count followed by that
+// many candles.
+func (r *Reader) ReadCandles() ([]Candle, error) {
+	n, err := r.ReadInt32()
+	if err != nil {
+		return nil, err
+	}
+
+	if n < 0 {
+		return nil, errors.New("negative candle count")
+	}
+
+	candles := make([]Candle, 0, min(n, 1024))
+
+	for range n {
+		var c Candle
+
+		if err := binary.Read(r.r, binary.BigEndian, &c); err != nil {
+			return nil, err
+		}
+
+		candles = append(candles, c)
+	}
+
+	return candles, nil
+}
+
+// Writer writes messages, or responses, to a stream.
+type Writer struct {
+	w io.Writer
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) WriteMessage(m Message) error {
+	out, err := Encode(m)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.w.Write(out)
+
+	return err
+}
+
+func (w *Writer) WriteInt32(v int32) error {
+	return binary.Write(w.w, binary.BigEndian, v)
+}
+
+func (w *Writer) WriteInt64(v int64) error {
+	return binary.Write(w.w, binary.BigEndian, v)
+}
+
+// WriteCandles writes the answer to an OHLC message in one write.
+func (w *Writer) WriteCandles(candles []Candle) error {
+	out := make([]byte, 0, 4+len(candles)*CandleSize)
+	out = appendInt32s(out, int32(len(candles)))
+
+	for _, c := range candles {
+		out = appendInt32s(out, c.Start, c.Open, c.High, c.Low, c.Close)
+	}
+
+	_, err := w.w.Write(out)
+
+	return err
+}
@@ -1,42 +1,344 @@
-package main
+package means
 
 import (
-	"github.com/tidwall/btree"
+	"cmp"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/bcatubig/protohackers/2_means_to_an_end/codec"
 )
 
+// DB holds a session's prices by timestamp. Count, Sum, Mean, Min and Max
+// take O(log n) time regardless of how many prices fall in the range. A DB
+// is safe for concurrent use.
 type DB struct {
-	db *btree.Map[int32, int32]
+	mu   sync.RWMutex
+	tree *tree
+
+	retention Retention
+
+	// every timestamp below downsampled has been merged into a bucket
+	downsampled int64
+}
+
+// Retention bounds how much history a DB keeps, relative to the newest
+// timestamp it holds.
+//
+// Downsampled prices are merged into buckets of DownsampleWidth seconds
+// aligned to multiples of the width. A bucket keeps the count, sum, min and
+// max of its prices, so means, sums and counts over it stay exact, and it
+// falls within a queried range when its start does. Median, percentile and
+// OHLC queries see a bucket as its mean price.
+type Retention struct {
+	// Window evicts prices more than Window seconds older than the newest.
+	// Zero keeps everything.
+	Window int32
+
+	// DownsampleAfter is the age in seconds past which prices are merged
+	// into buckets of DownsampleWidth seconds. A zero width disables
+	// downsampling.
+	DownsampleAfter int32
+	DownsampleWidth int32
 }
 
 func NewDB() *DB {
 	return &DB{
-		db: new(btree.Map[int32, int32]),
+		tree:        &tree{},
+		downsampled: math.MinInt32,
 	}
 }
 
-func (d *DB) Insert(timestamp, cents int32) {
-	d.db.Set(timestamp, cents)
+// SetRetention applies r to the prices already stored and every later
+// insert.
+func (d *DB) SetRetention(r Retention) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.retention = r
+	d.compact()
+}
+
+// Insert stores a price, replacing any price already at timestamp. It
+// returns how much the number of entries changed, which may be negative when
+// the insert made older entries expire or merge.
+func (d *DB) Insert(timestamp, cents int32) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	before := d.tree.root.size()
+
+	d.insert(timestamp, cents)
+	d.compact()
+
+	return d.tree.root.size() - before
+}
+
+func (d *DB) insert(timestamp, cents int32) {
+	r := d.retention
+
+	if newest, ok := d.tree.last(); ok && r.Window > 0 && int64(timestamp) < int64(newest)-int64(r.Window) {
+		return
+	}
+
+	if r.DownsampleWidth > 0 && int64(timestamp) < d.downsampled {
+		d.tree.merge(bucketStart(int64(timestamp), r.DownsampleWidth), price(cents))
+		return
+	}
+
+	d.tree.set(timestamp, cents)
+}
+
+// compact evicts and downsamples whatever the retention policy no longer
+// keeps as it is.
+func (d *DB) compact() {
+	r := d.retention
+
+	newest, ok := d.tree.last()
+	if !ok {
+		return
+	}
+
+	if r.Window > 0 {
+		cutoff := int64(newest) - int64(r.Window)
+
+		for first, ok := d.tree.first(); ok && int64(first) < cutoff; first, ok = d.tree.first() {
+			d.tree.delete(first)
+		}
+	}
+
+	if r.DownsampleWidth <= 0 {
+		return
+	}
+
+	cutoff := bucketStart(int64(newest)-int64(r.DownsampleAfter), r.DownsampleWidth)
+
+	if int64(cutoff) <= d.downsampled {
+		return
+	}
+
+	type entry struct {
+		timestamp int32
+		prices    aggregate
+	}
+
+	var raw []entry
+
+	d.tree.ascend(int32(d.downsampled), cutoff-1, func(timestamp int32, a aggregate) bool {
+		raw = append(raw, entry{timestamp, a})
+		return true
+	})
+
+	for _, e := range raw {
+		d.tree.delete(e.timestamp)
+	}
+
+	for _, e := range raw {
+		d.tree.merge(bucketStart(int64(e.timestamp), r.DownsampleWidth), e.prices)
+	}
+
+	d.downsampled = int64(cutoff)
+}
+
+// bucketStart returns the start of the width second bucket timestamp falls
+// in, clamped to the int32 range.
+func bucketStart(timestamp int64, width int32) int32 {
+	w := int64(width)
+	start := timestamp / w * w
+
+	if start > timestamp {
+		start -= w
+	}
+
+	return int32(max(start, math.MinInt32))
 }
 
 func (d *DB) Mean(minTime, maxTime int32) int {
-	var result int
-	var count int
-	d.db.Ascend(minTime, func(key, value int32) bool {
-		if key >= minTime && key <= maxTime {
-			result += int(value)
-			count++
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return int(d.tree.query(minTime, maxTime).mean())
+}
+
+// Each calls fn for every entry in timestamp order until fn returns false.
+// A downsampled bucket is passed as its mean price.
+func (d *DB) Each(fn func(timestamp, cents int32) bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	d.tree.ascend(math.MinInt32, math.MaxInt32, func(timestamp int32, a aggregate) bool {
+		return fn(timestamp, a.mean())
+	})
+}
+
+// Len returns the number of entries, counting a downsampled bucket as one.
+func (d *DB) Len() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.tree.root.size()
+}
+
+// Count returns the number of prices between minTime and maxTime inclusive.
+func (d *DB) Count(minTime, maxTime int32) int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.tree.query(minTime, maxTime).count
+}
+
+// Sum returns the total of the prices between minTime and maxTime inclusive.
+func (d *DB) Sum(minTime, maxTime int32) int64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	return d.tree.query(minTime, maxTime).sum
+}
+
+// Min returns the lowest price between minTime and maxTime inclusive, or
+// false if there are none.
+func (d *DB) Min(minTime, maxTime int32) (int32, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	a := d.tree.query(minTime, maxTime)
+
+	return a.min, a.count > 0
+}
+
+// Max returns the highest price between minTime and maxTime inclusive, or
+// false if there are none.
+func (d *DB) Max(minTime, maxTime int32) (int32, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	a := d.tree.query(minTime, maxTime)
+
+	return a.max, a.count > 0
+}
+
+// Median returns the middle price between minTime and maxTime inclusive. With
+// an even number of prices it is the mean of the two middle ones, rounded
+// towards zero.
+func (d *DB) Median(minTime, maxTime int32) (int32, bool) {
+	prices := d.sorted(minTime, maxTime)
+
+	if prices.count == 0 {
+		return 0, false
+	}
+
+	mid := prices.count / 2
+
+	if prices.count%2 == 1 {
+		return prices.nth(mid), true
+	}
+
+	return int32((int64(prices.nth(mid-1)) + int64(prices.nth(mid))) / 2), true
+}
+
+// Percentile returns the nearest-rank pth percentile of the prices between
+// minTime and maxTime inclusive: the smallest price that is greater than or
+// equal to p percent of them. p must be between 0 and 100.
+func (d *DB) Percentile(minTime, maxTime int32, p int) (int32, bool) {
+	if p < 0 || p > 100 {
+		return 0, false
+	}
+
+	prices := d.sorted(minTime, maxTime)
+
+	if prices.count == 0 {
+		return 0, false
+	}
+
+	rank := (p*prices.count + 99) / 100
+
+	return prices.nth(max(rank, 1) - 1), true
+}
+
+// Candle is the first, highest, lowest and last price in a time bucket.
+type Candle = codec.Candle
+
+// maxCandles bounds the number of buckets an OHLC query may return.
+const maxCandles = 1024
+
+// OHLC splits minTime to maxTime inclusive into buckets of width seconds,
+// starting at minTime, and returns a Candle for each bucket that holds at
+// least one price. Buckets past the first maxCandles are dropped.
+func (d *DB) OHLC(minTime, maxTime, width int32) []Candle {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	if width <= 0 {
+		return nil
+	}
+
+	var candles []Candle
+
+	d.tree.ascend(minTime, maxTime, func(timestamp int32, a aggregate) bool {
+		start := int32(int64(minTime) + (int64(timestamp)-int64(minTime))/int64(width)*int64(width))
+
+		if n := len(candles); n > 0 && candles[n-1].Start == start {
+			c := &candles[n-1]
+			c.High = max(c.High, a.max)
+			c.Low = min(c.Low, a.min)
+			c.Close = a.mean()
 
 			return true
 		}
 
-		return false
+		if len(candles) == maxCandles {
+			return false
+		}
+
+		candles = append(candles, Candle{Start: start, Open: a.mean(), High: a.max, Low: a.min, Close: a.mean()})
+
+		return true
 	})
 
-	if count == 0 {
-		return 0
+	return candles
+}
+
+// weightedPrices are prices in ascending order, each standing for count
+// prices.
+type weightedPrices struct {
+	prices []weightedPrice
+	count  int
+}
+
+type weightedPrice struct {
+	cents int32
+	count int
+}
+
+// nth returns the price at 0-based rank n.
+func (w weightedPrices) nth(n int) int32 {
+	for _, p := range w.prices {
+		if n < p.count {
+			return p.cents
+		}
+
+		n -= p.count
 	}
 
-	logger.Info("mean results", "total_items", d.db.Len(), "sum", result, "count", count)
+	return 0
+}
+
+func (d *DB) sorted(minTime, maxTime int32) weightedPrices {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	var w weightedPrices
+
+	d.tree.ascend(minTime, maxTime, func(_ int32, a aggregate) bool {
+		w.prices = append(w.prices, weightedPrice{cents: a.mean(), count: a.count})
+		w.count += a.count
+
+		return true
+	})
+
+	slices.SortFunc(w.prices, func(a, b weightedPrice) int {
+		return cmp.Compare(a.cents, b.cents)
+	})
 
-	return result / count
+	return w
 }
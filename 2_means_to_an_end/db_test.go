@@ -1,9 +1,12 @@
-package main
+package means
 
 import (
+	"math/rand/v2"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/tidwall/btree"
 )
 
 func TestDBInsert(t *testing.T) {
@@ -20,3 +23,250 @@ func TestDBInsert(t *testing.T) {
 		assert.Equal(t, 101, got)
 	})
 }
+
+func TestDBAggregates(t *testing.T) {
+	db := NewDB()
+
+	db.Insert(10, 5)
+	db.Insert(11, 1)
+	db.Insert(12, 9)
+	db.Insert(13, 3)
+	db.Insert(50, 100)
+
+	t.Run("count and sum", func(t *testing.T) {
+		assert.Equal(t, 4, db.Count(10, 13))
+		assert.Equal(t, int64(18), db.Sum(10, 13))
+		assert.Equal(t, 0, db.Count(20, 40))
+	})
+
+	t.Run("min and max", func(t *testing.T) {
+		got, ok := db.Min(10, 13)
+		assert.True(t, ok)
+		assert.Equal(t, int32(1), got)
+
+		got, ok = db.Max(10, 13)
+		assert.True(t, ok)
+		assert.Equal(t, int32(9), got)
+
+		_, ok = db.Min(20, 40)
+		assert.False(t, ok)
+	})
+
+	t.Run("median", func(t *testing.T) {
+		got, _ := db.Median(10, 13)
+		assert.Equal(t, int32(4), got)
+
+		got, _ = db.Median(10, 50)
+		assert.Equal(t, int32(5), got)
+	})
+
+	t.Run("percentile", func(t *testing.T) {
+		for p, want := range map[int]int32{0: 1, 25: 1, 50: 3, 75: 5, 100: 9} {
+			got, ok := db.Percentile(10, 13, p)
+			assert.True(t, ok)
+			assert.Equal(t, want, got, "p%d", p)
+		}
+
+		_, ok := db.Percentile(10, 13, 101)
+		assert.False(t, ok)
+	})
+
+	t.Run("ohlc", func(t *testing.T) {
+		got := db.OHLC(10, 59, 2)
+
+		assert.Equal(t, []Candle{
+			{Start: 10, Open: 5, High: 5, Low: 1, Close: 1},
+			{Start: 12, Open: 9, High: 9, Low: 3, Close: 3},
+			{Start: 50, Open: 100, High: 100, Low: 100, Close: 100},
+		}, got)
+
+		assert.Nil(t, db.OHLC(10, 59, 0))
+	})
+}
+
+func TestDBRetention(t *testing.T) {
+	t.Run("window", func(t *testing.T) {
+		db := NewDB()
+		db.SetRetention(Retention{Window: 10})
+
+		for i := range int32(101) {
+			db.Insert(i, i)
+		}
+
+		assert.Equal(t, 11, db.Len())
+		assert.Equal(t, 11, db.Count(0, 100))
+
+		got, _ := db.Min(0, 100)
+		assert.Equal(t, int32(90), got)
+
+		assert.Equal(t, 0, db.Insert(50, 1), "prices older than the window are dropped")
+		// evicts 90 to 94
+		assert.Equal(t, -4, db.Insert(105, 1))
+	})
+
+	t.Run("downsampling", func(t *testing.T) {
+		db := NewDB()
+		db.SetRetention(Retention{DownsampleAfter: 10, DownsampleWidth: 5})
+
+		for i := range int32(30) {
+			db.Insert(i, i)
+		}
+
+		// 0 to 14 are merged into three buckets, 15 to 29 are kept as is
+		assert.Equal(t, 18, db.Len())
+		assert.Equal(t, 30, db.Count(0, 29))
+		assert.Equal(t, int64(435), db.Sum(0, 29))
+
+		// spans downsampled and raw prices: 5 to 20
+		assert.Equal(t, 12, db.Mean(5, 20))
+
+		got, _ := db.Min(5, 9)
+		assert.Equal(t, int32(5), got)
+
+		got, _ = db.Max(5, 9)
+		assert.Equal(t, int32(9), got)
+
+		// buckets count as their mean, 12 for 10 to 14
+		got, _ = db.Median(0, 29)
+		assert.Equal(t, int32(13), got)
+
+		// a late price joins its bucket
+		assert.Equal(t, 0, db.Insert(3, 100))
+		assert.Equal(t, 6, db.Count(0, 4))
+		assert.Equal(t, int64(110), db.Sum(0, 4))
+	})
+
+	t.Run("negative timestamps", func(t *testing.T) {
+		db := NewDB()
+		db.SetRetention(Retention{DownsampleAfter: 0, DownsampleWidth: 10})
+
+		db.Insert(-15, 1)
+		db.Insert(-5, 3)
+		db.Insert(0, 5)
+
+		assert.Equal(t, 3, db.Len())
+		assert.Equal(t, 1, db.Count(-20, -11))
+		assert.Equal(t, 1, db.Count(-10, -1))
+	})
+}
+
+func TestDBConcurrent(t *testing.T) {
+	db := NewDB()
+
+	var wg sync.WaitGroup
+
+	for p := range int32(4) {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range int32(1000) {
+				db.Insert(p*1000+i, 10)
+			}
+		}()
+	}
+
+	for range 4 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range int32(1000) {
+				if mean := db.Mean(0, i*4); mean != 0 {
+					assert.Equal(t, 10, mean)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, 4000, db.Count(0, 4000))
+}
+
+const benchEntries = 1_000_000
+
+// btreeMean is how DB.Mean used to work: a scan over a btree.Map.
+func btreeMean(m *btree.Map[int32, int32], minTime, maxTime int32) int {
+	var result int
+	var count int
+
+	m.Ascend(minTime, func(key, value int32) bool {
+		if key > maxTime {
+			return false
+		}
+
+		result += int(value)
+		count++
+
+		return true
+	})
+
+	if count == 0 {
+		return 0
+	}
+
+	return result / count
+}
+
+func benchRanges() [][2]int32 {
+	r := rand.New(rand.NewPCG(1, 2))
+	ranges := make([][2]int32, 1024)
+
+	for i := range ranges {
+		minTime := r.Int32N(benchEntries)
+		ranges[i] = [2]int32{minTime, minTime + r.Int32N(benchEntries-minTime)}
+	}
+
+	return ranges
+}
+
+func BenchmarkMean(b *testing.B) {
+	ranges := benchRanges()
+
+	b.Run("tree", func(b *testing.B) {
+		db := NewDB()
+
+		for i := range int32(benchEntries) {
+			db.Insert(i, i%1000)
+		}
+
+		for i := 0; b.Loop(); i++ {
+			r := ranges[i%len(ranges)]
+			db.Mean(r[0], r[1])
+		}
+	})
+
+	b.Run("btree", func(b *testing.B) {
+		m := new(btree.Map[int32, int32])
+
+		for i := range int32(benchEntries) {
+			m.Set(i, i%1000)
+		}
+
+		for i := 0; b.Loop(); i++ {
+			r := ranges[i%len(ranges)]
+			btreeMean(m, r[0], r[1])
+		}
+	})
+}
+
+func BenchmarkInsert(b *testing.B) {
+	b.Run("tree", func(b *testing.B) {
+		db := NewDB()
+
+		for i := 0; b.Loop(); i++ {
+			db.Insert(int32(i%benchEntries), int32(i))
+		}
+	})
+
+	b.Run("btree", func(b *testing.B) {
+		m := new(btree.Map[int32, int32])
+
+		for i := 0; b.Loop(); i++ {
+			m.Set(int32(i%benchEntries), int32(i))
+		}
+	})
+}
@@ -0,0 +1,15 @@
+package means
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/bcatubig/protohackers/golden"
+	"github.com/bcatubig/protohackers/server"
+)
+
+func TestGolden(t *testing.T) {
+	s := newServer(WithLogger(slog.New(slog.DiscardHandler)))
+
+	golden.Run(t, "testdata", server.HandlerFunc(s.handle))
+}
@@ -0,0 +1,327 @@
+package means
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// A record is a big-endian timestamp and price followed by a CRC32 of both,
+// so a record torn by a crash or flipped on disk is detected on recovery.
+const recordSize = 12
+
+const defaultSnapshotEvery = 10000
+
+var (
+	ErrSessionInUse   = errors.New("session is already attached")
+	ErrInvalidSession = errors.New("invalid session id")
+)
+
+var reSessionID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// journal makes a session's DB durable with an append-only log of inserts
+// and a periodic snapshot of the whole DB. After a snapshot is written the
+// log starts over. The log is synced to disk after every append, or at most
+// once per syncEvery when that is set, in which case a crash loses the
+// inserts since the last sync.
+type journal struct {
+	id  string
+	db  *DB
+	log *os.File
+
+	logPath  string
+	snapPath string
+
+	appended      int
+	snapshotEvery int
+
+	syncEvery time.Duration
+	synced    time.Time
+}
+
+// openJournal recovers the session id stored in dir, replaying its snapshot
+// and then its log. A trailing partial or corrupt log record, left behind by
+// a crash mid-write, is discarded and cut off the log.
+func openJournal(dir, id string, snapshotEvery int) (*journal, error) {
+	if !reSessionID.MatchString(id) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidSession, id)
+	}
+
+	if snapshotEvery <= 0 {
+		snapshotEvery = defaultSnapshotEvery
+	}
+
+	j := &journal{
+		id:            id,
+		db:            NewDB(),
+		logPath:       filepath.Join(dir, id+".log"),
+		snapPath:      filepath.Join(dir, id+".snap"),
+		snapshotEvery: snapshotEvery,
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	if _, err := j.replay(j.snapPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading snapshot: %w", err)
+	}
+
+	good, err := j.replay(j.logPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading log: %w", err)
+	}
+
+	log, err := os.OpenFile(j.logPath, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := log.Truncate(good); err != nil {
+		log.Close()
+		return nil, err
+	}
+
+	if _, err := log.Seek(good, io.SeekStart); err != nil {
+		log.Close()
+		return nil, err
+	}
+
+	j.log = log
+
+	return j, nil
+}
+
+// replay inserts every intact record in path into the DB and returns the
+// offset just past the last one.
+func (j *journal) replay(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	record := make([]byte, recordSize)
+
+	var good int64
+
+	for {
+		_, err := io.ReadFull(reader, record)
+
+		// io.ErrUnexpectedEOF is a torn final record
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return good, nil
+		}
+
+		if err != nil {
+			return good, err
+		}
+
+		timestamp, cents, ok := decodeRecord(record)
+		if !ok {
+			return good, nil
+		}
+
+		j.db.Insert(timestamp, cents)
+		good += recordSize
+	}
+}
+
+// Insert stores a price and appends it to the log, taking a snapshot every
+// snapshotEvery inserts. Like DB.Insert it returns how much the number of
+// entries changed.
+func (j *journal) Insert(timestamp, cents int32) (int, error) {
+	delta := j.db.Insert(timestamp, cents)
+
+	if _, err := j.log.Write(encodeRecord(timestamp, cents)); err != nil {
+		return delta, err
+	}
+
+	if now := time.Now(); j.syncEvery == 0 || now.Sub(j.synced) >= j.syncEvery {
+		if err := j.log.Sync(); err != nil {
+			return delta, err
+		}
+
+		j.synced = now
+	}
+
+	j.appended++
+
+	if j.appended < j.snapshotEvery {
+		return delta, nil
+	}
+
+	return delta, j.snapshot()
+}
+
+// snapshot atomically replaces the snapshot with the current DB contents and
+// empties the log.
+func (j *journal) snapshot() error {
+	tmp := j.snapPath + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+
+	j.db.Each(func(timestamp, cents int32) bool {
+		_, err = w.Write(encodeRecord(timestamp, cents))
+		return err == nil
+	})
+
+	if err == nil {
+		err = w.Flush()
+	}
+
+	if err == nil {
+		err = f.Sync()
+	}
+
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, j.snapPath); err != nil {
+		return err
+	}
+
+	// the rename must be on disk before the log it replaces is emptied
+	if err := syncDir(filepath.Dir(j.snapPath)); err != nil {
+		return err
+	}
+
+	if err := j.log.Truncate(0); err != nil {
+		return err
+	}
+
+	if _, err := j.log.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	j.appended = 0
+
+	return nil
+}
+
+// Close snapshots the DB and closes the log.
+func (j *journal) Close() error {
+	err := j.snapshot()
+
+	if closeErr := j.log.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// syncDir syncs the directory dir, making renames within it durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+
+	err = d.Sync()
+
+	if closeErr := d.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+func encodeRecord(timestamp, cents int32) []byte {
+	record := make([]byte, recordSize)
+
+	binary.BigEndian.PutUint32(record[0:4], uint32(timestamp))
+	binary.BigEndian.PutUint32(record[4:8], uint32(cents))
+	binary.BigEndian.PutUint32(record[8:12], crc32.ChecksumIEEE(record[:8]))
+
+	return record
+}
+
+func decodeRecord(record []byte) (int32, int32, bool) {
+	if crc32.ChecksumIEEE(record[:8]) != binary.BigEndian.Uint32(record[8:12]) {
+		return 0, 0, false
+	}
+
+	timestamp := int32(binary.BigEndian.Uint32(record[0:4]))
+	cents := int32(binary.BigEndian.Uint32(record[4:8]))
+
+	return timestamp, cents, true
+}
+
+// sessions hands out durable sessions stored in dir, making sure each one
+// is attached to at most one connection at a time.
+type sessions struct {
+	dir           string
+	snapshotEvery int
+	syncEvery     time.Duration
+
+	// attached holds the sessions in use, including ones still being read
+	// from disk
+	mu       sync.Mutex
+	attached map[string]struct{}
+}
+
+func newSessions(dir string, snapshotEvery int) *sessions {
+	return &sessions{
+		dir:           dir,
+		snapshotEvery: snapshotEvery,
+		attached:      make(map[string]struct{}),
+	}
+}
+
+// attach opens session id. The session is claimed before it is read from
+// disk, without holding the lock, so a large session doesn't hold up other
+// clients attaching.
+func (s *sessions) attach(id string) (*journal, error) {
+	s.mu.Lock()
+
+	if _, ok := s.attached[id]; ok {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s", ErrSessionInUse, id)
+	}
+
+	s.attached[id] = struct{}{}
+	s.mu.Unlock()
+
+	j, err := openJournal(s.dir, id, s.snapshotEvery)
+	if err != nil {
+		s.mu.Lock()
+		delete(s.attached, id)
+		s.mu.Unlock()
+
+		return nil, err
+	}
+
+	j.syncEvery = s.syncEvery
+
+	return j, nil
+}
+
+func (s *sessions) detach(j *journal) error {
+	err := j.Close()
+
+	s.mu.Lock()
+	delete(s.attached, j.id)
+	s.mu.Unlock()
+
+	return err
+}
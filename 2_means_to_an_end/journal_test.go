@@ -0,0 +1,210 @@
+package means
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// crash closes the journal's log without taking a snapshot, like a process
+// that was killed.
+func crash(t *testing.T, j *journal) {
+	t.Helper()
+	require.NoError(t, j.log.Close())
+}
+
+func TestJournal(t *testing.T) {
+	t.Run("survives a clean close", func(t *testing.T) {
+		dir := t.TempDir()
+
+		j, err := openJournal(dir, "session", 100)
+		require.NoError(t, err)
+
+		_, err = j.Insert(12345, 101)
+		require.NoError(t, err)
+		_, err = j.Insert(12346, 102)
+		require.NoError(t, err)
+		require.NoError(t, j.Close())
+
+		j, err = openJournal(dir, "session", 100)
+		require.NoError(t, err)
+		defer j.Close()
+
+		assert.Equal(t, 2, j.db.Len())
+		assert.Equal(t, 101, j.db.Mean(12345, 12346))
+	})
+
+	t.Run("replays the log after a crash", func(t *testing.T) {
+		dir := t.TempDir()
+
+		j, err := openJournal(dir, "session", 100)
+		require.NoError(t, err)
+
+		_, err = j.Insert(1, 10)
+		require.NoError(t, err)
+		_, err = j.Insert(2, 20)
+		require.NoError(t, err)
+		crash(t, j)
+
+		j, err = openJournal(dir, "session", 100)
+		require.NoError(t, err)
+		defer j.Close()
+
+		assert.Equal(t, 2, j.db.Len())
+	})
+
+	t.Run("combines snapshot and log", func(t *testing.T) {
+		dir := t.TempDir()
+
+		j, err := openJournal(dir, "session", 3)
+		require.NoError(t, err)
+
+		for i := int32(1); i <= 5; i++ {
+			_, err = j.Insert(i, i*10)
+			require.NoError(t, err)
+		}
+		crash(t, j)
+
+		info, err := os.Stat(filepath.Join(dir, "session.log"))
+		require.NoError(t, err)
+		assert.Equal(t, int64(2*recordSize), info.Size())
+
+		j, err = openJournal(dir, "session", 3)
+		require.NoError(t, err)
+		defer j.Close()
+
+		assert.Equal(t, 5, j.db.Len())
+		assert.Equal(t, 30, j.db.Mean(1, 5))
+	})
+
+	t.Run("drops a record truncated mid-write", func(t *testing.T) {
+		dir := t.TempDir()
+		logPath := filepath.Join(dir, "session.log")
+
+		j, err := openJournal(dir, "session", 100)
+		require.NoError(t, err)
+
+		for i := int32(1); i <= 3; i++ {
+			_, err = j.Insert(i, 100)
+			require.NoError(t, err)
+		}
+		crash(t, j)
+
+		require.NoError(t, os.Truncate(logPath, 3*recordSize-5))
+
+		j, err = openJournal(dir, "session", 100)
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, j.db.Len())
+
+		info, err := os.Stat(logPath)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2*recordSize), info.Size(), "torn record is cut off the log")
+
+		// new records land after the last intact one
+		_, err = j.Insert(4, 400)
+		require.NoError(t, err)
+		crash(t, j)
+
+		j, err = openJournal(dir, "session", 100)
+		require.NoError(t, err)
+		defer j.Close()
+
+		assert.Equal(t, 3, j.db.Len())
+		assert.Equal(t, 400, j.db.Mean(4, 4))
+	})
+
+	t.Run("stops at a corrupt record", func(t *testing.T) {
+		dir := t.TempDir()
+		logPath := filepath.Join(dir, "session.log")
+
+		j, err := openJournal(dir, "session", 100)
+		require.NoError(t, err)
+
+		for i := int32(1); i <= 3; i++ {
+			_, err = j.Insert(i, 100)
+			require.NoError(t, err)
+		}
+		crash(t, j)
+
+		data, err := os.ReadFile(logPath)
+		require.NoError(t, err)
+		data[recordSize+2] ^= 0xff
+		require.NoError(t, os.WriteFile(logPath, data, 0o644))
+
+		j, err = openJournal(dir, "session", 100)
+		require.NoError(t, err)
+		defer j.Close()
+
+		assert.Equal(t, 1, j.db.Len())
+	})
+
+	t.Run("fails on an unreadable snapshot", func(t *testing.T) {
+		dir := t.TempDir()
+
+		// reading a directory fails with something other than EOF
+		require.NoError(t, os.Mkdir(filepath.Join(dir, "session.snap"), 0o755))
+
+		_, err := openJournal(dir, "session", 100)
+		assert.ErrorContains(t, err, "reading snapshot")
+	})
+
+	t.Run("rejects unsafe ids", func(t *testing.T) {
+		_, err := openJournal(t.TempDir(), "../escape", 100)
+		assert.ErrorIs(t, err, ErrInvalidSession)
+	})
+}
+
+func TestSessions(t *testing.T) {
+	s := newSessions(t.TempDir(), 100)
+
+	j, err := s.attach("abc")
+	require.NoError(t, err)
+
+	_, err = s.attach("abc")
+	assert.ErrorIs(t, err, ErrSessionInUse)
+
+	require.NoError(t, s.detach(j))
+
+	j, err = s.attach("abc")
+	require.NoError(t, err)
+	require.NoError(t, s.detach(j))
+
+	// a session that failed to open isn't left claimed
+	_, err = s.attach("bad id")
+	require.ErrorIs(t, err, ErrInvalidSession)
+	assert.NotContains(t, s.attached, "bad id")
+}
+
+func TestSessionsConcurrentAttach(t *testing.T) {
+	s := newSessions(t.TempDir(), 100)
+
+	journals := make(chan *journal, 10)
+	wg := &sync.WaitGroup{}
+
+	for range 10 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			j, err := s.attach("abc")
+			if err != nil {
+				assert.ErrorIs(t, err, ErrSessionInUse)
+				return
+			}
+
+			journals <- j
+		}()
+	}
+
+	wg.Wait()
+	close(journals)
+
+	require.Len(t, journals, 1)
+	require.NoError(t, s.detach(<-journals))
+}
@@ -0,0 +1,136 @@
+package means
+
+import (
+	"errors"
+	"time"
+	"unsafe"
+)
+
+// entrySize is roughly what a stored price costs: one tree node.
+const entrySize = int64(unsafe.Sizeof(node{}))
+
+// A client that trips a limit is disconnected.
+var (
+	ErrEntryLimit  = errors.New("entry limit reached")
+	ErrMemoryLimit = errors.New("memory budget exhausted")
+	ErrRateLimit   = errors.New("message rate limit exceeded")
+)
+
+// WithMaxEntries caps the number of prices a connection's own DB or a
+// durable session may hold. In a shared namespace it caps the prices each
+// connection adds, so one client can't use up everyone else's quota; see
+// WithMaxNamespaceEntries for the namespace as a whole. Updating the price at
+// an existing timestamp counts as an insert.
+func WithMaxEntries(n int) ServerOpt {
+	return func(s *Server) {
+		s.maxEntries = n
+	}
+}
+
+// WithMaxNamespaceEntries caps the number of prices a shared namespace may
+// hold across all of its clients, past and present.
+func WithMaxNamespaceEntries(n int) ServerOpt {
+	return func(s *Server) {
+		s.maxNamespaceEntries = n
+	}
+}
+
+// WithMemoryBudget caps the memory, in bytes, taken up by prices across every
+// connection. It is an estimate based on the size of the tree nodes.
+func WithMemoryBudget(bytes int64) ServerOpt {
+	return func(s *Server) {
+		s.memoryBudget = bytes
+	}
+}
+
+// WithRateLimit caps the number of messages per second a connection may
+// send, allowing bursts of up to burst messages.
+func WithRateLimit(perSecond float64, burst int) ServerOpt {
+	return func(s *Server) {
+		s.rate = perSecond
+		s.burst = burst
+	}
+}
+
+// admit checks whether one more insert by a client holding entries prices
+// stays within the entry limit, and reserves memory for it. The caller
+// settles the reservation with charge(delta-1) once it knows how much the
+// insert changed the number of entries.
+func (s *Server) admit(entries int) error {
+	if s.maxEntries > 0 && entries >= s.maxEntries {
+		return ErrEntryLimit
+	}
+
+	// reserving before checking keeps concurrent inserts from all fitting
+	// into the last free entry
+	if used := s.memoryUsed.Add(entrySize); s.memoryBudget > 0 && used > s.memoryBudget {
+		s.memoryUsed.Add(-entrySize)
+		return ErrMemoryLimit
+	}
+
+	return nil
+}
+
+// admitShared is admit for a connection that has added prices to a namespace
+// holding total. Concurrent inserts may overshoot the namespace's cap by one
+// price each; the memory budget is the hard limit.
+func (s *Server) admitShared(added, total int) error {
+	if s.maxNamespaceEntries > 0 && total >= s.maxNamespaceEntries {
+		return ErrEntryLimit
+	}
+
+	return s.admit(added)
+}
+
+// charge accounts for entries prices being stored, or released when
+// negative.
+func (s *Server) charge(entries int) {
+	s.memoryGauge.Set(float64(s.memoryUsed.Add(int64(entries) * entrySize)))
+}
+
+var limitNames = map[error]string{
+	ErrEntryLimit:  "entries",
+	ErrMemoryLimit: "memory",
+	ErrRateLimit:   "rate",
+}
+
+// limitExceeded records that the client at ip tripped the limit err stands
+// for. The caller disconnects it.
+func (s *Server) limitExceeded(err error, ip string) {
+	s.logger.Error("limit exceeded, disconnecting", "error", err.Error(), "ip", ip)
+	s.metrics.Counter("protohackers_limit_exceeded_total", "Clients disconnected for exceeding a limit.", "server", "means", "limit", limitNames[err]).Inc()
+}
+
+// rateLimiter is a token bucket. It isn't safe for concurrent use; each
+// connection has its own.
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(perSecond float64, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:   perSecond,
+		burst:  float64(max(burst, 1)),
+		tokens: float64(max(burst, 1)),
+	}
+}
+
+// allow takes a token if one is available at now.
+func (r *rateLimiter) allow(now time.Time) bool {
+	if !r.last.IsZero() {
+		r.tokens = min(r.burst, r.tokens+now.Sub(r.last).Seconds()*r.rate)
+	}
+
+	r.last = now
+
+	if r.tokens < 1 {
+		return false
+	}
+
+	r.tokens--
+
+	return true
+}
@@ -0,0 +1,221 @@
+package means
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bcatubig/protohackers/2_means_to_an_end/codec"
+	"github.com/bcatubig/protohackers/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serve runs s.handle on one end of a pipe and returns the other end along
+// with a channel that is closed once handle returns.
+func serve(t *testing.T, s *Server) (net.Conn, <-chan struct{}) {
+	t.Helper()
+
+	client, srv := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		s.handle(context.Background(), srv)
+		srv.Close()
+	}()
+
+	return client, done
+}
+
+func limitCount(r *metrics.Registry, limit string) float64 {
+	return r.Counter("protohackers_limit_exceeded_total", "", "server", "means", "limit", limit).Value()
+}
+
+func TestLimits(t *testing.T) {
+	t.Run("entries", func(t *testing.T) {
+		reg := metrics.NewRegistry()
+		s := newServer(WithLogger(slog.New(slog.DiscardHandler)), WithMetrics(reg), WithMaxEntries(2))
+
+		client, done := serve(t, s)
+
+		for i := range int32(3) {
+			client.Write(message('I', i, 10))
+		}
+
+		_, err := client.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, io.EOF)
+		<-done
+
+		assert.Equal(t, 1.0, limitCount(reg, "entries"))
+	})
+
+	t.Run("entries are per client in a namespace", func(t *testing.T) {
+		reg := metrics.NewRegistry()
+		s := newServer(WithLogger(slog.New(slog.DiscardHandler)), WithMetrics(reg), WithNamespaces(), WithMaxEntries(2))
+
+		ns, err := codec.NewNamespace("shared")
+		require.NoError(t, err)
+
+		nsMsg, err := codec.Encode(ns)
+		require.NoError(t, err)
+
+		for client := range int32(2) {
+			conn, _ := serve(t, s)
+			conn.Write(nsMsg)
+
+			for i := range int32(2) {
+				conn.Write(message('I', client*10+i, 10))
+			}
+
+			conn.Write(message('C', 0, 100))
+
+			count := make([]byte, 4)
+			_, err := io.ReadFull(conn, count)
+			require.NoError(t, err)
+			assert.Equal(t, []byte{0, 0, 0, byte(client*2 + 2)}, count)
+		}
+
+		assert.Zero(t, limitCount(reg, "entries"))
+	})
+
+	t.Run("namespace total survives reconnects", func(t *testing.T) {
+		reg := metrics.NewRegistry()
+		s := newServer(WithLogger(slog.New(slog.DiscardHandler)), WithMetrics(reg), WithNamespaces(), WithMaxEntries(2), WithMaxNamespaceEntries(3))
+
+		ns, err := codec.NewNamespace("shared")
+		require.NoError(t, err)
+
+		nsMsg, err := codec.Encode(ns)
+		require.NoError(t, err)
+
+		// the same client, reconnecting once its own quota is used up
+		for conn := range int32(2) {
+			client, done := serve(t, s)
+			client.Write(nsMsg)
+
+			for i := range int32(2) {
+				client.Write(message('I', conn*10+i, 10))
+			}
+
+			if conn == 0 {
+				client.Close()
+				<-done
+				continue
+			}
+
+			_, err := client.Read(make([]byte, 1))
+			assert.ErrorIs(t, err, io.EOF)
+			<-done
+		}
+
+		assert.Equal(t, 1.0, limitCount(reg, "entries"))
+		assert.Equal(t, 3, s.namespaces.get("shared").Len())
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		reg := metrics.NewRegistry()
+		s := newServer(WithLogger(slog.New(slog.DiscardHandler)), WithMetrics(reg), WithMemoryBudget(3*entrySize))
+
+		first, firstDone := serve(t, s)
+
+		for i := range int32(2) {
+			first.Write(message('I', i, 10))
+		}
+
+		// the query is only answered once both inserts were stored
+		first.Write(message('C', 0, 10))
+		_, err := io.ReadFull(first, make([]byte, 4))
+		require.NoError(t, err)
+
+		second, secondDone := serve(t, s)
+
+		for i := range int32(2) {
+			second.Write(message('I', i, 10))
+		}
+
+		_, err = second.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, io.EOF)
+		<-secondDone
+
+		assert.Equal(t, 1.0, limitCount(reg, "memory"))
+		assert.Equal(t, 2*entrySize, s.memoryUsed.Load())
+
+		first.Close()
+		<-firstDone
+
+		assert.Equal(t, int64(0), s.memoryUsed.Load(), "memory is released with the connection")
+	})
+
+	t.Run("rate", func(t *testing.T) {
+		reg := metrics.NewRegistry()
+		s := newServer(WithLogger(slog.New(slog.DiscardHandler)), WithMetrics(reg), WithRateLimit(0.001, 2))
+
+		client, done := serve(t, s)
+
+		for i := range int32(3) {
+			client.Write(message('I', i, 10))
+		}
+
+		_, err := client.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, io.EOF)
+		<-done
+
+		assert.Equal(t, 1.0, limitCount(reg, "rate"))
+	})
+}
+
+func TestAdmitConcurrent(t *testing.T) {
+	const budget = 100
+
+	s := newServer(WithLogger(slog.New(slog.DiscardHandler)), WithMemoryBudget(budget*entrySize))
+
+	var admitted atomic.Int32
+
+	wg := &sync.WaitGroup{}
+
+	for range 8 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for range budget {
+				if s.admit(0) == nil {
+					admitted.Add(1)
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	assert.Equal(t, int32(budget), admitted.Load())
+	assert.Equal(t, budget*entrySize, s.memoryUsed.Load())
+}
+
+func TestRateLimiter(t *testing.T) {
+	r := newRateLimiter(10, 2)
+	now := time.Now()
+
+	assert.True(t, r.allow(now))
+	assert.True(t, r.allow(now))
+	assert.False(t, r.allow(now))
+
+	assert.True(t, r.allow(now.Add(100*time.Millisecond)))
+	assert.False(t, r.allow(now.Add(100*time.Millisecond)))
+
+	// tokens never pile up beyond the burst
+	later := now.Add(time.Minute)
+	assert.True(t, r.allow(later))
+	assert.True(t, r.allow(later))
+	assert.False(t, r.allow(later))
+}
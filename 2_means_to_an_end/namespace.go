@@ -0,0 +1,32 @@
+package means
+
+import (
+	"sync"
+)
+
+// namespaces hands out the DBs shared by every connection that attaches to
+// the same namespace. Namespaces live in memory for as long as the server.
+type namespaces struct {
+	mu  sync.Mutex
+	dbs map[string]*DB
+}
+
+func newNamespaces() *namespaces {
+	return &namespaces{
+		dbs: make(map[string]*DB),
+	}
+}
+
+// get returns the DB for name, creating it on first use.
+func (n *namespaces) get(name string) *DB {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	db, ok := n.dbs[name]
+	if !ok {
+		db = NewDB()
+		n.dbs[name] = db
+	}
+
+	return db
+}
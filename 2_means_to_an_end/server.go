@@ -1,111 +1,370 @@
-package main
+package means
 
 import (
-	"bytes"
-	"encoding/binary"
+	"context"
+	"encoding/hex"
 	"errors"
 	"io"
+	"log/slog"
 	"net"
+	"os"
+	"sync/atomic"
 	"time"
+
+	"github.com/bcatubig/protohackers/2_means_to_an_end/codec"
+	"github.com/bcatubig/protohackers/metrics"
+	"github.com/bcatubig/protohackers/server"
 )
 
 type Server struct {
-	l net.Listener
+	*server.Server
+
+	logger   *slog.Logger
+	metrics  *metrics.Registry
+	requests *metrics.Requests
+
+	sessions       *sessions
+	defaultSession string
+	syncInterval   time.Duration
+
+	namespaces *namespaces
+
+	maxEntries          int
+	maxNamespaceEntries int
+	memoryBudget        int64
+	memoryUsed          atomic.Int64
+	memoryGauge         *metrics.Gauge
+	rate                float64
+	burst               int
+
+	retention Retention
+}
+
+type ServerOpt func(s *Server)
+
+func WithLogger(l *slog.Logger) ServerOpt {
+	return func(s *Server) {
+		s.logger = l
+	}
+}
+
+func WithMetrics(r *metrics.Registry) ServerOpt {
+	return func(s *Server) {
+		s.metrics = r
+	}
 }
 
-func NewServer(addr string) (*Server, error) {
-	l, err := net.Listen("tcp", addr)
+// WithPersistence keeps sessions in dir so prices survive reconnects and
+// restarts. A client attaches to a session by sending an S message with an
+// 8 byte session id as its first message. A snapshot is taken every
+// snapshotEvery inserts.
+func WithPersistence(dir string, snapshotEvery int) ServerOpt {
+	return func(s *Server) {
+		s.sessions = newSessions(dir, snapshotEvery)
+	}
+}
+
+// WithSyncInterval syncs session logs to disk at most once per d instead of
+// after every insert, trading the inserts since the last sync on a crash
+// for throughput. It has no effect without WithPersistence.
+func WithSyncInterval(d time.Duration) ServerOpt {
+	return func(s *Server) {
+		s.syncInterval = d
+	}
+}
+
+// WithDefaultSession attaches clients that don't send an S message to the
+// durable session id. It requires WithPersistence.
+func WithDefaultSession(id string) ServerOpt {
+	return func(s *Server) {
+		s.defaultSession = id
+	}
+}
+
+// WithNamespaces lets clients share prices. A client that sends an N message
+// with an 8 byte name as its first message reads and writes the same DB as
+// every other client that sent that name. Namespaces are kept in memory.
+func WithNamespaces() ServerOpt {
+	return func(s *Server) {
+		s.namespaces = newNamespaces()
+	}
+}
+
+// WithRetention evicts prices more than window seconds older than the newest
+// price in their DB.
+func WithRetention(window int32) ServerOpt {
+	return func(s *Server) {
+		s.retention.Window = window
+	}
+}
+
+// WithDownsampling merges prices more than after seconds older than the
+// newest price in their DB into buckets of width seconds. See Retention for
+// how queries treat buckets. It can't be combined with WithPersistence.
+func WithDownsampling(after, width int32) ServerOpt {
+	return func(s *Server) {
+		s.retention.DownsampleAfter = after
+		s.retention.DownsampleWidth = width
+	}
+}
+
+func NewServer(addr string, opts ...ServerOpt) (*Server, error) {
+	s := newServer(opts...)
+
+	// snapshots hold one price per timestamp, so they can't restore a bucket
+	if s.sessions != nil && s.retention.DownsampleWidth > 0 {
+		return nil, errors.New("means: downsampling can't be combined with persistence")
+	}
+
+	srv, err := server.New(addr, server.HandlerFunc(s.handle), server.WithLogger(s.logger), server.WithMetrics(s.metrics, "means"))
 	if err != nil {
 		return nil, err
 	}
 
-	return &Server{l: l}, nil
+	s.Server = srv
+
+	return s, nil
 }
 
-func (s *Server) ListenAndServe() error {
-	for {
-		c, err := s.l.Accept()
-		if err != nil {
-			logger.Error(err.Error())
-			continue
-		}
+// newServer applies opts and fills in defaults without listening.
+func newServer(opts ...ServerOpt) *Server {
+	s := &Server{}
 
-		logger.Info("new connection", "ip", c.RemoteAddr().String())
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.logger == nil {
+		s.logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
 
-		c.SetDeadline(time.Time{})
-		go s.handle(c)
+	if s.metrics == nil {
+		s.metrics = metrics.NewRegistry()
 	}
+
+	if s.sessions != nil {
+		s.sessions.syncEvery = s.syncInterval
+	}
+
+	s.requests = s.metrics.Requests("means")
+	s.memoryGauge = s.metrics.Gauge("protohackers_means_memory_bytes", "Estimated memory taken up by stored prices.", "server", "means")
+
+	return s
 }
 
-func (s *Server) handle(c net.Conn) {
-	defer c.Close()
+// handle serves a single client. Besides the I and Q messages from the
+// challenge it answers these aggregate queries over a timestamp range, each
+// a 9 byte message of an opcode and two int32 timestamps unless noted:
+//
+//	L  lowest price, int32
+//	H  highest price, int32
+//	M  median price, int32
+//	U  sum of prices, int64
+//	C  number of prices, int32
+//	P  nth percentile, int32; a 13 byte message with n as a third int32
+//	O  OHLC candles; a 13 byte message with the bucket width in seconds as a
+//	   third int32, answered by an int32 count followed by that many 20 byte
+//	   candles of start, open, high, low and close
+//
+// Queries over an empty range answer 0. The first message may instead be an
+// S or N message attaching the connection to a durable session or a shared
+// namespace, see WithPersistence and WithNamespaces.
+func (s *Server) handle(ctx context.Context, c net.Conn) {
+	ip := c.RemoteAddr().String()
+
+	s.logger.Info("new connection", "ip", ip)
+
+	reader := codec.NewReader(c)
+	writer := codec.NewWriter(c)
+
+	db := NewDB()
+	db.SetRetention(s.retention)
+
+	shared := false
+
+	// added counts this client's prices in a shared namespace
+	added := 0
+
+	var j *journal
 
 	defer func() {
-		if r := recover(); r != nil {
-			logger.Error("recovered from panic", "r", r)
+		// prices in a namespace outlive the connection
+		if !shared {
+			s.charge(-db.Len())
+		}
+
+		if j == nil {
+			return
+		}
+
+		if err := s.sessions.detach(j); err != nil {
+			s.logger.Error("error closing session", "session", j.id, "error", err.Error())
 		}
 	}()
 
-	db := NewDB()
+	var limiter *rateLimiter
+
+	if s.rate > 0 {
+		limiter = newRateLimiter(s.rate, s.burst)
+	}
+
+	first := true
 
 	for {
-		buf := new(bytes.Buffer)
+		if ctx.Err() != nil {
+			s.logger.Info("server shutting down, closing connection", "ip", ip)
+			return
+		}
 
-		w, err := io.CopyN(buf, c, 9)
+		msg, err := reader.ReadMessage()
 
-		if err != nil {
-			if errors.Is(err, io.EOF) {
-				logger.Info("EOF. client disconnected", "ip", c.RemoteAddr().String())
-				return
-			}
-			logger.Error("error copying data", "error", err.Error(), "ip", c.RemoteAddr().String())
+		var unknown *codec.UnknownOpError
+
+		switch {
+		case errors.Is(err, io.EOF):
+			s.logger.Info("EOF. client disconnected", "ip", ip)
+			return
+		case errors.As(err, &unknown):
+			s.logger.Error("invalid message", "error", err.Error(), "ip", ip)
+			s.requests.Malformed()
 			continue
+		case err != nil:
+			s.logger.Error("error reading message", "error", err.Error(), "ip", ip)
+			return
 		}
 
-		if w < 9 {
-			logger.Error("did not read 9 bytes of data", "ip", c.RemoteAddr().String())
-			continue
+		start := time.Now()
+
+		if limiter != nil && !limiter.allow(start) {
+			s.limitExceeded(ErrRateLimit, ip)
+			return
 		}
 
-		op := buf.Next(1)
-		num1 := buf.Next(4)
-		num2 := buf.Next(4)
-
-		switch string(op) {
-		case "I":
-			timestamp := parseInt32(num1)
-			cents := parseInt32(num2)
-			logger.Info("got insert message", "timestamp", timestamp, "cents", cents, "ip", c.RemoteAddr().String())
-			db.Insert(timestamp, cents)
-		case "Q":
-			minTime := parseInt32(num1)
-			maxTime := parseInt32(num2)
-			logger.Info("got query message", "minTime", minTime, "maxTime", maxTime, "ip", c.RemoteAddr().String())
-
-			if minTime > maxTime {
-				logger.Error("minTime greater than maxTime", "minTime", minTime, "maxTime", maxTime, "ip", c.RemoteAddr().String())
-				binary.Write(c, binary.BigEndian, int32(0))
+		if first {
+			first = false
+
+			if m, ok := msg.(codec.Namespace); ok && s.namespaces != nil {
+				db = s.namespaces.get(m.String())
+				db.SetRetention(s.retention)
+				shared = true
+
+				s.logger.Info("attached namespace", "namespace", m.String(), "ip", ip)
+				s.requests.Observe("N", start)
+
 				continue
 			}
 
-			mean := db.Mean(minTime, maxTime)
+			var id string
 
-			binary.Write(c, binary.BigEndian, int32(mean))
+			switch m := msg.(type) {
+			case codec.Session:
+				if s.sessions != nil {
+					id = hex.EncodeToString(m.ID[:])
+				}
+			default:
+				if s.sessions != nil {
+					id = s.defaultSession
+				}
+			}
+
+			if id != "" {
+				j, err = s.sessions.attach(id)
+				if err != nil {
+					s.logger.Error("error attaching session", "session", id, "error", err.Error(), "ip", ip)
+					return
+				}
+
+				s.logger.Info("attached session", "session", id, "entries", j.db.Len(), "ip", ip)
+				db = j.db
+				db.SetRetention(s.retention)
+				s.charge(db.Len())
+			}
 
+			if _, ok := msg.(codec.Session); ok && j != nil {
+				s.requests.Observe("S", start)
+				continue
+			}
+		}
+
+		switch m := msg.(type) {
+		case codec.Insert:
+			s.logger.Info("got insert message", "timestamp", m.Timestamp, "cents", m.Price, "ip", ip)
+
+			if shared {
+				err = s.admitShared(added, db.Len())
+			} else {
+				err = s.admit(db.Len())
+			}
+
+			if err != nil {
+				s.limitExceeded(err, ip)
+				return
+			}
+
+			var delta int
+
+			if j != nil {
+				delta, err = j.Insert(m.Timestamp, m.Price)
+			} else {
+				delta = db.Insert(m.Timestamp, m.Price)
+			}
+
+			s.charge(delta - 1)
+
+			if shared {
+				added = max(added+delta, 0)
+			}
+
+			if err != nil {
+				s.logger.Error("error writing to session log", "session", j.id, "error", err.Error())
+				return
+			}
+		case codec.Query:
+			s.logger.Info("got query message", "minTime", m.MinTime, "maxTime", m.MaxTime, "ip", ip)
+
+			if m.MinTime > m.MaxTime {
+				s.logger.Error("minTime greater than maxTime", "minTime", m.MinTime, "maxTime", m.MaxTime, "ip", ip)
+			}
+
+			err = writer.WriteInt32(int32(db.Mean(m.MinTime, m.MaxTime)))
+		case codec.Aggregate:
+			err = s.aggregate(writer, db, m)
+		case codec.Percentile:
+			percentile, _ := db.Percentile(m.MinTime, m.MaxTime, int(m.N))
+			err = writer.WriteInt32(percentile)
+		case codec.OHLC:
+			err = writer.WriteCandles(db.OHLC(m.MinTime, m.MaxTime, m.Width))
 		default:
-			logger.Error("invalid message")
+			s.logger.Error("invalid message", "op", msg.Op().String(), "ip", ip)
+			s.requests.Malformed()
+			continue
+		}
+
+		if err != nil {
+			s.logger.Error("error writing response", "error", err.Error(), "ip", ip)
+			return
 		}
+
+		s.requests.Observe(msg.Op().String(), start)
 	}
 }
 
-func parseInt32(data []byte) int32 {
-	var result int32
+// aggregate answers the L, H, M, U and C queries.
+func (s *Server) aggregate(w *codec.Writer, db *DB, m codec.Aggregate) error {
+	var v int32
 
-	reader := bytes.NewReader(data)
-	err := binary.Read(reader, binary.BigEndian, &result)
-	if err != nil {
-		panic(err)
+	switch m.Kind {
+	case codec.OpMin:
+		v, _ = db.Min(m.MinTime, m.MaxTime)
+	case codec.OpMax:
+		v, _ = db.Max(m.MinTime, m.MaxTime)
+	case codec.OpMedian:
+		v, _ = db.Median(m.MinTime, m.MaxTime)
+	case codec.OpSum:
+		return w.WriteInt64(db.Sum(m.MinTime, m.MaxTime))
+	case codec.OpCount:
+		v = int32(db.Count(m.MinTime, m.MaxTime))
 	}
 
-	return result
+	return w.WriteInt32(v)
 }
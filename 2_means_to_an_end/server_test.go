@@ -0,0 +1,142 @@
+package means
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func message(op byte, nums ...int32) []byte {
+	out := []byte{op}
+
+	for _, n := range nums {
+		out = binary.BigEndian.AppendUint32(out, uint32(n))
+	}
+
+	return out
+}
+
+func TestHandleAggregates(t *testing.T) {
+	s := newServer(WithLogger(slog.New(slog.DiscardHandler)))
+
+	client, srv := net.Pipe()
+	defer client.Close()
+
+	go func() {
+		s.handle(context.Background(), srv)
+		srv.Close()
+	}()
+
+	for _, m := range [][]byte{
+		message('I', 1, 10),
+		message('I', 2, 30),
+		message('I', 3, 20),
+	} {
+		_, err := client.Write(m)
+		require.NoError(t, err)
+	}
+
+	read := func(n int) []byte {
+		t.Helper()
+
+		buf := make([]byte, n)
+		_, err := io.ReadFull(client, buf)
+		require.NoError(t, err)
+
+		return buf
+	}
+
+	tests := []struct {
+		name string
+		msg  []byte
+		want []byte
+	}{
+		{"mean", message('Q', 1, 3), message(0, 20)[1:]},
+		{"min", message('L', 1, 3), message(0, 10)[1:]},
+		{"max", message('H', 1, 3), message(0, 30)[1:]},
+		{"median", message('M', 1, 3), message(0, 20)[1:]},
+		{"sum", message('U', 1, 3), message(0, 0, 60)[1:]},
+		{"count", message('C', 1, 3), message(0, 3)[1:]},
+		{"percentile", message('P', 1, 3, 90), message(0, 30)[1:]},
+		{"empty", message('L', 100, 200), message(0, 0)[1:]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.Write(tt.msg)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.want, read(len(tt.want)))
+		})
+	}
+
+	t.Run("ohlc", func(t *testing.T) {
+		_, err := client.Write(message('O', 1, 3, 2))
+		require.NoError(t, err)
+
+		assert.Equal(t, message(0, 2, 1, 10, 30, 10, 30, 3, 20, 20, 20, 20)[1:], read(4+2*20))
+	})
+}
+
+func TestHandleNamespaces(t *testing.T) {
+	s := newServer(WithLogger(slog.New(slog.DiscardHandler)), WithNamespaces())
+
+	connect := func(name string) net.Conn {
+		client, srv := net.Pipe()
+		t.Cleanup(func() { client.Close() })
+
+		go func() {
+			s.handle(context.Background(), srv)
+			srv.Close()
+		}()
+
+		_, err := client.Write(append([]byte{'N'}, fmt.Appendf(nil, "%-8s", name)...))
+		require.NoError(t, err)
+
+		return client
+	}
+
+	producer := connect("btc")
+	consumer := connect("btc")
+	other := connect("eth")
+
+	for _, m := range [][]byte{message('I', 1, 10), message('I', 2, 30)} {
+		_, err := producer.Write(m)
+		require.NoError(t, err)
+	}
+
+	// the producer's query is answered after both inserts were applied
+	_, err := producer.Write(message('C', 0, 10))
+	require.NoError(t, err)
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(producer, buf)
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		conn net.Conn
+		want int32
+	}{
+		{consumer, 20},
+		{other, 0},
+	} {
+		_, err := tt.conn.Write(message('Q', 0, 10))
+		require.NoError(t, err)
+
+		_, err = io.ReadFull(tt.conn, buf)
+		require.NoError(t, err)
+		assert.Equal(t, message(0, tt.want)[1:], buf)
+	}
+}
+
+func TestNewServerDownsamplingWithPersistence(t *testing.T) {
+	_, err := NewServer("127.0.0.1:0", WithPersistence(t.TempDir(), 0), WithDownsampling(60, 60))
+	assert.Error(t, err)
+}
@@ -0,0 +1,317 @@
+package means
+
+import (
+	"math/rand/v2"
+)
+
+// tree is a treap keyed by timestamp in which every node also keeps the
+// count, sum, min and max of the prices in its subtree, so those aggregates
+// over any timestamp range take O(log n) expected time.
+//
+// A node usually holds a single price, but downsampling merges many prices
+// into one node at the start of their bucket.
+type tree struct {
+	root *node
+}
+
+type node struct {
+	timestamp int32
+	priority  uint32
+
+	left, right *node
+
+	// self covers the prices at this node and all those in its whole subtree.
+	self aggregate
+	all  aggregate
+
+	// entries is the number of nodes in the subtree.
+	entries int
+}
+
+// aggregate accumulates the count, sum, min and max of a set of prices.
+type aggregate struct {
+	count    int
+	sum      int64
+	min, max int32
+}
+
+func price(cents int32) aggregate {
+	return aggregate{count: 1, sum: int64(cents), min: cents, max: cents}
+}
+
+func (a *aggregate) add(b aggregate) {
+	if b.count == 0 {
+		return
+	}
+
+	if a.count == 0 {
+		*a = b
+		return
+	}
+
+	a.count += b.count
+	a.sum += b.sum
+	a.min = min(a.min, b.min)
+	a.max = max(a.max, b.max)
+}
+
+// mean is the mean of the prices, rounded towards zero.
+func (a aggregate) mean() int32 {
+	if a.count == 0 {
+		return 0
+	}
+
+	return int32(a.sum / int64(a.count))
+}
+
+func (n *node) update() {
+	n.all = n.self
+	n.entries = 1
+
+	if n.left != nil {
+		n.all.add(n.left.all)
+		n.entries += n.left.entries
+	}
+
+	if n.right != nil {
+		n.all.add(n.right.all)
+		n.entries += n.right.entries
+	}
+}
+
+func (n *node) size() int {
+	if n == nil {
+		return 0
+	}
+
+	return n.entries
+}
+
+// set stores cents at timestamp, replacing whatever is already there. It
+// reports whether timestamp is new.
+func (t *tree) set(timestamp, cents int32) bool {
+	return t.put(timestamp, price(cents), false)
+}
+
+// merge adds the prices in a to those at timestamp. It reports whether
+// timestamp is new.
+func (t *tree) merge(timestamp int32, a aggregate) bool {
+	return t.put(timestamp, a, true)
+}
+
+func (t *tree) put(timestamp int32, a aggregate, merge bool) bool {
+	before := t.root.size()
+	t.root = t.root.insert(timestamp, a, merge)
+
+	return t.root.size() > before
+}
+
+func (n *node) insert(timestamp int32, a aggregate, merge bool) *node {
+	if n == nil {
+		n = &node{timestamp: timestamp, priority: rand.Uint32(), self: a}
+		n.update()
+
+		return n
+	}
+
+	switch {
+	case timestamp < n.timestamp:
+		n.left = n.left.insert(timestamp, a, merge)
+
+		if n.left.priority > n.priority {
+			n = n.rotateRight()
+		}
+	case timestamp > n.timestamp:
+		n.right = n.right.insert(timestamp, a, merge)
+
+		if n.right.priority > n.priority {
+			n = n.rotateLeft()
+		}
+	case merge:
+		n.self.add(a)
+	default:
+		n.self = a
+	}
+
+	n.update()
+
+	return n
+}
+
+// delete removes the node at timestamp, if there is one.
+func (t *tree) delete(timestamp int32) {
+	t.root = t.root.delete(timestamp)
+}
+
+func (n *node) delete(timestamp int32) *node {
+	if n == nil {
+		return nil
+	}
+
+	switch {
+	case timestamp < n.timestamp:
+		n.left = n.left.delete(timestamp)
+	case timestamp > n.timestamp:
+		n.right = n.right.delete(timestamp)
+	default:
+		return merge(n.left, n.right)
+	}
+
+	n.update()
+
+	return n
+}
+
+// merge joins two treaps where every key in l is below every key in r.
+func merge(l, r *node) *node {
+	switch {
+	case l == nil:
+		return r
+	case r == nil:
+		return l
+	case l.priority > r.priority:
+		l.right = merge(l.right, r)
+		l.update()
+
+		return l
+	default:
+		r.left = merge(l, r.left)
+		r.update()
+
+		return r
+	}
+}
+
+// first returns the lowest timestamp, or false if the tree is empty.
+func (t *tree) first() (int32, bool) {
+	n := t.root
+
+	if n == nil {
+		return 0, false
+	}
+
+	for n.left != nil {
+		n = n.left
+	}
+
+	return n.timestamp, true
+}
+
+// last returns the highest timestamp, or false if the tree is empty.
+func (t *tree) last() (int32, bool) {
+	n := t.root
+
+	if n == nil {
+		return 0, false
+	}
+
+	for n.right != nil {
+		n = n.right
+	}
+
+	return n.timestamp, true
+}
+
+func (n *node) rotateRight() *node {
+	l := n.left
+	n.left = l.right
+	l.right = n
+
+	n.update()
+
+	return l
+}
+
+func (n *node) rotateLeft() *node {
+	r := n.right
+	n.right = r.left
+	r.left = n
+
+	n.update()
+
+	return r
+}
+
+// query returns the aggregate of the prices between minTime and maxTime
+// inclusive.
+func (t *tree) query(minTime, maxTime int32) aggregate {
+	var a aggregate
+
+	if minTime > maxTime {
+		return a
+	}
+
+	// find the highest node inside the range; everything in range is in its
+	// subtree
+	n := t.root
+
+	for n != nil && (n.timestamp < minTime || n.timestamp > maxTime) {
+		if n.timestamp < minTime {
+			n = n.right
+		} else {
+			n = n.left
+		}
+	}
+
+	if n == nil {
+		return a
+	}
+
+	// the left subtree only has a lower bound to check
+	for l := n.left; l != nil; {
+		if l.timestamp >= minTime {
+			if l.right != nil {
+				a.add(l.right.all)
+			}
+
+			a.add(l.self)
+			l = l.left
+		} else {
+			l = l.right
+		}
+	}
+
+	a.add(n.self)
+
+	// and the right subtree only an upper bound
+	for r := n.right; r != nil; {
+		if r.timestamp <= maxTime {
+			if r.left != nil {
+				a.add(r.left.all)
+			}
+
+			a.add(r.self)
+			r = r.right
+		} else {
+			r = r.left
+		}
+	}
+
+	return a
+}
+
+// ascend calls fn for every node between minTime and maxTime inclusive in
+// timestamp order until fn returns false.
+func (t *tree) ascend(minTime, maxTime int32, fn func(timestamp int32, a aggregate) bool) {
+	t.root.ascend(minTime, maxTime, fn)
+}
+
+func (n *node) ascend(minTime, maxTime int32, fn func(timestamp int32, a aggregate) bool) bool {
+	if n == nil {
+		return true
+	}
+
+	if n.timestamp > minTime && !n.left.ascend(minTime, maxTime, fn) {
+		return false
+	}
+
+	if n.timestamp >= minTime && n.timestamp <= maxTime && !fn(n.timestamp, n.self) {
+		return false
+	}
+
+	if n.timestamp < maxTime {
+		return n.right.ascend(minTime, maxTime, fn)
+	}
+
+	return true
+}
@@ -0,0 +1,87 @@
+package means
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTreeQuery(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+
+	tr := &tree{}
+	prices := map[int32]int32{}
+
+	for range 2000 {
+		timestamp := r.Int32N(1000)
+		cents := r.Int32N(2000) - 1000
+
+		tr.set(timestamp, cents)
+		prices[timestamp] = cents
+	}
+
+	assert.Equal(t, len(prices), tr.root.size())
+
+	for range 500 {
+		minTime := r.Int32N(1100) - 50
+		maxTime := minTime + r.Int32N(300)
+
+		var want aggregate
+
+		for timestamp, cents := range prices {
+			if timestamp >= minTime && timestamp <= maxTime {
+				want.add(price(cents))
+			}
+		}
+
+		assert.Equal(t, want, tr.query(minTime, maxTime), "range %d..%d", minTime, maxTime)
+	}
+}
+
+func TestTreeAscend(t *testing.T) {
+	tr := &tree{}
+
+	for _, timestamp := range []int32{5, 1, 4, 2, 3} {
+		tr.set(timestamp, timestamp*10)
+	}
+
+	var got []int32
+
+	tr.ascend(2, 4, func(timestamp int32, _ aggregate) bool {
+		got = append(got, timestamp)
+		return true
+	})
+
+	assert.Equal(t, []int32{2, 3, 4}, got)
+}
+
+func TestTreeDelete(t *testing.T) {
+	r := rand.New(rand.NewPCG(3, 4))
+
+	tr := &tree{}
+	prices := map[int32]int32{}
+
+	for range 1000 {
+		timestamp := r.Int32N(500)
+
+		tr.set(timestamp, timestamp)
+		prices[timestamp] = timestamp
+	}
+
+	for range 300 {
+		timestamp := r.Int32N(500)
+
+		tr.delete(timestamp)
+		delete(prices, timestamp)
+	}
+
+	var want aggregate
+
+	for _, cents := range prices {
+		want.add(price(cents))
+	}
+
+	assert.Equal(t, len(prices), tr.root.size())
+	assert.Equal(t, want, tr.query(0, 500))
+}
@@ -0,0 +1,114 @@
+package chat
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// command is a slash command. run gets the rest of the line, trimmed.
+type command struct {
+	usage string
+	help  string
+	run   func(s *Server, c *conn, arg string)
+}
+
+var commands map[string]command
+
+func init() {
+	// filled in here since some commands look themselves up in it
+	commands = map[string]command{
+		"/join":  {"/join #room", "move to another room", cmdJoin},
+		"/part":  {"/part", "go back to " + defaultRoom, cmdPart},
+		"/rooms": {"/rooms", "list the rooms with people in them", cmdRooms},
+		"/msg":   {"/msg <user> <text>", "send a private message", cmdMsg},
+		"/who":   {"/who", "list the people in your room", cmdWho},
+		"/me":    {"/me <action>", "describe what you're doing", cmdMe},
+		"/nick":  {"/nick <name>", "change your name", cmdNick},
+		"/help":  {"/help", "list the commands", cmdHelp},
+	}
+}
+
+// handleCommand runs a line starting with a slash. Replies only go to c.
+func (s *Server) handleCommand(c *conn, line string) {
+	name, arg, _ := strings.Cut(line, " ")
+
+	cmd, ok := commands[name]
+	if !ok {
+		s.sendMessage(c, fmt.Sprintf("* Unknown command: %s, try /help", name))
+		return
+	}
+
+	cmd.run(s, c, strings.TrimSpace(arg))
+}
+
+func cmdMsg(s *Server, c *conn, arg string) {
+	to, text, _ := strings.Cut(arg, " ")
+	text = strings.TrimSpace(text)
+
+	if to == "" || text == "" {
+		s.sendMessage(c, "* Usage: "+commands["/msg"].usage)
+		return
+	}
+
+	target := s.findUser(to)
+
+	if target == nil {
+		s.sendMessage(c, fmt.Sprintf("* No such user: %s", to))
+		return
+	}
+
+	s.sendMessage(target, fmt.Sprintf("[%s -> you] %s", c.username, text))
+	s.sendMessage(c, fmt.Sprintf("[you -> %s] %s", to, text))
+}
+
+func cmdWho(s *Server, c *conn, _ string) {
+	names := append(s.members(c), c.username)
+	slices.Sort(names)
+
+	s.sendMessage(c, fmt.Sprintf("* In %s: %s", c.room, strings.Join(names, " ")))
+}
+
+func cmdMe(s *Server, c *conn, arg string) {
+	if arg == "" {
+		s.sendMessage(c, "* Usage: "+commands["/me"].usage)
+		return
+	}
+
+	s.say(c, fmt.Sprintf("* %s %s", c.username, arg))
+}
+
+func cmdNick(s *Server, c *conn, arg string) {
+	old := c.username
+
+	if arg == old {
+		s.sendMessage(c, fmt.Sprintf("* You are already known as %s", old))
+		return
+	}
+
+	if err := s.claimName(c, arg); err != nil {
+		s.sendMessage(c, fmt.Sprintf("* Can't change name: %s", strings.TrimPrefix(err.Error(), "* ")))
+		return
+	}
+
+	s.broadcast(c, fmt.Sprintf("* %s is now known as %s", old, arg))
+	s.sendMessage(c, fmt.Sprintf("* You are now known as %s", arg))
+}
+
+func cmdHelp(s *Server, c *conn, _ string) {
+	for _, name := range slices.Sorted(maps.Keys(commands)) {
+		s.sendMessage(c, fmt.Sprintf("* %-20s %s", commands[name].usage, commands[name].help))
+	}
+}
+
+// findUser returns the joined conn called name, or nil.
+func (s *Server) findUser(name string) *conn {
+	for c := range s.activeConn {
+		if c.joined && c.username == name {
+			return c
+		}
+	}
+
+	return nil
+}
@@ -1,15 +1,38 @@
-package main
+package chat
 
 import (
+	"bufio"
 	"fmt"
 	"net"
+	"sync"
 )
 
 type conn struct {
-	rwc      net.Conn
-	ip       string
+	rwc    net.Conn
+	reader *bufio.Reader
+	ip     string
+
+	// only the hub touches these
 	username string
 	joined   bool
+	room     string
+
+	// queue holds messages waiting for writeLoop. queueMu guards sending on
+	// and closing it.
+	queue       chan string
+	queueMu     sync.Mutex
+	queueClosed bool
+	writerDone  chan struct{}
+}
+
+func newConn(rwc net.Conn, queueSize int) *conn {
+	return &conn{
+		rwc:        rwc,
+		reader:     bufio.NewReaderSize(rwc, 2048),
+		ip:         rwc.RemoteAddr().String(),
+		queue:      make(chan string, queueSize),
+		writerDone: make(chan struct{}),
+	}
 }
 
 func (c *conn) Write(b []byte) (int, error) {
@@ -20,11 +43,19 @@ func (c *conn) Read(b []byte) (int, error) {
 	return c.rwc.Read(b)
 }
 
-func (c conn) String() string {
+func (c *conn) String() string {
 	return fmt.Sprintf("%s - %s", c.ip, c.username)
 }
 
+// closeQueue stops c from taking new messages. writeLoop still writes what
+// is queued.
+func (c *conn) closeQueue() {
+	if !c.queueClosed {
+		c.queueClosed = true
+		close(c.queue)
+	}
+}
+
 func (c *conn) close() {
-	logger.Info("closing connection", "ip", c.ip)
 	c.rwc.Close()
 }
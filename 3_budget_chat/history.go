@@ -0,0 +1,232 @@
+package chat
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ring holds the last len(lines) messages sent to a room.
+type ring struct {
+	lines []string
+	next  int
+	full  bool
+}
+
+func newRing(depth int) *ring {
+	return &ring{lines: make([]string, depth)}
+}
+
+func (r *ring) add(line string) {
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// all returns the messages oldest first.
+func (r *ring) all() []string {
+	if !r.full {
+		return slices.Clone(r.lines[:r.next])
+	}
+
+	return append(slices.Clone(r.lines[r.next:]), r.lines[:r.next]...)
+}
+
+// history keeps the recent messages of every room. With a dir each room's
+// messages are also appended to a file there, so history survives a
+// restart. Every depth messages the file is rewritten with just the buffered
+// ones to keep it from growing.
+type history struct {
+	depth int
+	dir   string
+
+	mu       sync.Mutex
+	rooms    map[string]*ring
+	files    map[string]*os.File
+	appended map[string]int
+}
+
+// newHistory keeps depth messages per room, loading any left in dir.
+func newHistory(depth int, dir string) (*history, error) {
+	h := &history{
+		depth:    depth,
+		dir:      dir,
+		rooms:    make(map[string]*ring),
+		files:    make(map[string]*os.File),
+		appended: make(map[string]int),
+	}
+
+	if dir == "" {
+		return h, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, path := range paths {
+		room := "#" + strings.TrimSuffix(filepath.Base(path), ".log")
+
+		if !reRoomName.MatchString(room) {
+			continue
+		}
+
+		if err := h.load(room, path); err != nil {
+			return nil, err
+		}
+	}
+
+	return h, nil
+}
+
+// load reads the messages in path into room's buffer. Lines may be as long
+// as any message; a last line without a newline was cut short by a crash and
+// is cut off the file, so the next message starts on a line of its own.
+func (h *history) load(room, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := newRing(h.depth)
+	reader := bufio.NewReader(f)
+
+	var good int64
+
+	for {
+		line, err := reader.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			if line != "" {
+				if err := os.Truncate(path, good); err != nil {
+					return err
+				}
+			}
+
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		r.add(strings.TrimSuffix(line, "\n"))
+		good += int64(len(line))
+	}
+
+	h.rooms[room] = r
+
+	return nil
+}
+
+func (h *history) path(room string) string {
+	return filepath.Join(h.dir, strings.TrimPrefix(room, "#")+".log")
+}
+
+// add records msg as sent to room.
+func (h *history) add(room, msg string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, ok := h.rooms[room]
+	if !ok {
+		r = newRing(h.depth)
+		h.rooms[room] = r
+	}
+
+	r.add(msg)
+
+	if h.dir == "" {
+		return nil
+	}
+
+	h.appended[room]++
+
+	if h.appended[room] >= h.depth {
+		return h.rewrite(room, r)
+	}
+
+	f, err := h.file(room)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteString(msg + "\n")
+
+	return err
+}
+
+// file returns room's log, opening it for appending if needed.
+func (h *history) file(room string) (*os.File, error) {
+	if f, ok := h.files[room]; ok {
+		return f, nil
+	}
+
+	f, err := os.OpenFile(h.path(room), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	h.files[room] = f
+
+	return f, nil
+}
+
+// rewrite atomically replaces room's log with the buffered messages.
+func (h *history) rewrite(room string, r *ring) error {
+	if f, ok := h.files[room]; ok {
+		f.Close()
+		delete(h.files, room)
+	}
+
+	h.appended[room] = 0
+
+	tmp := h.path(room) + ".tmp"
+
+	err := os.WriteFile(tmp, []byte(strings.Join(r.all(), "\n")+"\n"), 0o644)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, h.path(room))
+}
+
+// recent returns room's buffered messages, oldest first.
+func (h *history) recent(room string) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	r, ok := h.rooms[room]
+	if !ok {
+		return nil
+	}
+
+	return r.all()
+}
+
+func (h *history) close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var errs []error
+
+	for room, f := range h.files {
+		errs = append(errs, f.Close())
+		delete(h.files, room)
+	}
+
+	return errors.Join(errs...)
+}
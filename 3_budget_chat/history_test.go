@@ -0,0 +1,85 @@
+package chat
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRing(t *testing.T) {
+	r := newRing(3)
+	assert.Empty(t, r.all())
+
+	r.add("a")
+	r.add("b")
+	assert.Equal(t, []string{"a", "b"}, r.all())
+
+	r.add("c")
+	r.add("d")
+	r.add("e")
+	assert.Equal(t, []string{"c", "d", "e"}, r.all())
+}
+
+func TestHistoryJournal(t *testing.T) {
+	dir := t.TempDir()
+
+	h, err := newHistory(3, dir)
+	require.NoError(t, err)
+
+	// enough to rewrite the lobby's file once
+	for _, msg := range []string{"1", "2", "3", "4"} {
+		require.NoError(t, h.add("#lobby", msg))
+	}
+
+	require.NoError(t, h.add("#go", "hi"))
+	require.NoError(t, h.close())
+
+	h, err = newHistory(3, dir)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"2", "3", "4"}, h.recent("#lobby"))
+	assert.Equal(t, []string{"hi"}, h.recent("#go"))
+	assert.Nil(t, h.recent("#empty"))
+
+	// a smaller depth keeps the newest
+	h, err = newHistory(1, dir)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"4"}, h.recent("#lobby"))
+}
+
+func TestHistoryLongLines(t *testing.T) {
+	dir := t.TempDir()
+
+	h, err := newHistory(3, dir)
+	require.NoError(t, err)
+
+	long := strings.Repeat("x", 70<<10)
+
+	require.NoError(t, h.add("#lobby", long))
+	require.NoError(t, h.add("#lobby", "short"))
+	require.NoError(t, h.close())
+
+	// and a torn write from a crash
+	f, err := os.OpenFile(filepath.Join(dir, "lobby.log"), os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	f.WriteString("tor")
+	f.Close()
+
+	h, err = newHistory(3, dir)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{long, "short"}, h.recent("#lobby"))
+
+	require.NoError(t, h.add("#lobby", "next"))
+	require.NoError(t, h.close())
+
+	h, err = newHistory(3, dir)
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{long, "short", "next"}, h.recent("#lobby"))
+}
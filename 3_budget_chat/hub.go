@@ -0,0 +1,39 @@
+package chat
+
+// The hub is a single goroutine that owns activeConn and the username, room
+// and joined fields of every conn. Connection handlers only read from their
+// client and post what they read to it as clientMessages, so everything
+// touching who is where happens in one place, in the order it arrived.
+
+// run handles clientMessages until the server shuts down.
+func (s *Server) run() {
+	for {
+		select {
+		case m := <-s.clientMsgs:
+			s.dispatch(m)
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+func (s *Server) dispatch(m clientMessage) {
+	switch m.msgType {
+	case clientJoined:
+		m.result <- s.handleJoin(m.conn, m.data)
+	case clientDisconnected:
+		s.handleDisconnect(m.conn)
+	case clientData:
+		s.handleData(m.conn, m.data)
+	}
+}
+
+// post hands m to the hub. It returns false if the hub has stopped.
+func (s *Server) post(m clientMessage) bool {
+	select {
+	case s.clientMsgs <- m:
+		return true
+	case <-s.quit:
+		return false
+	}
+}
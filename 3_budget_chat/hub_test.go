@@ -0,0 +1,129 @@
+package chat
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJoinSameNameRace(t *testing.T) {
+	const clients = 50
+
+	addr := startServer(t)
+
+	var joined, taken atomic.Int32
+
+	wg := &sync.WaitGroup{}
+	start := make(chan struct{})
+
+	for range clients {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			conn, err := net.Dial("tcp", addr)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			// stay connected until everyone has tried
+			t.Cleanup(func() { conn.Close() })
+
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			reader := bufio.NewReader(conn)
+
+			reader.ReadString('\n')
+			<-start
+			conn.Write([]byte("alice\n"))
+
+			line, err := reader.ReadString('\n')
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			switch {
+			case strings.HasPrefix(line, "* The room contains:"):
+				joined.Add(1)
+			case line == "* username alice is already taken\n":
+				taken.Add(1)
+			default:
+				t.Errorf("unexpected line %q", line)
+			}
+		}()
+	}
+
+	close(start)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), joined.Load())
+	assert.Equal(t, int32(clients-1), taken.Load())
+}
+
+func TestJoinLeaveChurn(t *testing.T) {
+	const clients = 100
+
+	addr := startServer(t)
+
+	observer := join(t, addr, "observer")
+	observer.conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	wg := &sync.WaitGroup{}
+
+	for i := range clients {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			conn, err := net.Dial("tcp", addr)
+			if !assert.NoError(t, err) {
+				return
+			}
+			defer conn.Close()
+
+			conn.SetDeadline(time.Now().Add(5 * time.Second))
+			reader := bufio.NewReader(conn)
+
+			reader.ReadString('\n')
+			fmt.Fprintf(conn, "user%d\n", i)
+
+			// leave as soon as the join was answered, or even before
+			if i%2 == 0 {
+				reader.ReadString('\n')
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	entered := map[string]bool{}
+	left := 0
+
+	for left < clients {
+		line := observer.read()
+
+		if name, ok := strings.CutSuffix(strings.TrimPrefix(line, "* "), " has entered the room"); ok {
+			require.False(t, entered[name], "%s entered twice", name)
+			entered[name] = true
+			continue
+		}
+
+		name, ok := strings.CutSuffix(strings.TrimPrefix(line, "* "), " has left the room")
+		require.True(t, ok, line)
+		require.True(t, entered[name], "%s left before entering", name)
+
+		left++
+	}
+
+	observer.send("/who")
+	observer.expect("* In #lobby: observer")
+}
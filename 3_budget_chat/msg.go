@@ -1,12 +1,15 @@
-package main
+package chat
 
 import (
-	"bufio"
 	"errors"
 	"fmt"
+	"regexp"
 	"strings"
+	"time"
 )
 
+var reUsername = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
 type clientMessageType int
 
 const (
@@ -15,68 +18,98 @@ const (
 	clientData
 )
 
+// clientMessage is an event a connection's handler posts to the hub. For
+// clientJoined data is the requested username and result gets the outcome;
+// for clientData it is the line the client sent.
 type clientMessage struct {
 	msgType clientMessageType
 	conn    *conn
 	data    string
+	result  chan error
 }
 
 func (m clientMessage) String() string {
-	return fmt.Sprintf("%d %s %s", m.msgType, m.conn.username, m.data)
+	return fmt.Sprintf("%d %s %s", m.msgType, m.conn.ip, m.data)
 }
 
-func (s *Server) handleJoin(conn *conn) error {
-	// read in username
-	buf := bufio.NewReaderSize(conn, 64)
-	username, err := buf.ReadString('\n')
+// readUsername reads the line a new client names itself with.
+func (s *Server) readUsername(conn *conn) (string, error) {
+	username, err := conn.reader.ReadString('\n')
 
 	if err != nil {
-		logger.Error("error reading username", "error", err.Error(), "ip", conn.ip)
-		return errors.New("error reading username")
+		s.logger.Error("error reading username", "error", err.Error(), "ip", conn.ip)
+		return "", errors.New("error reading username")
 	}
 
 	username = strings.TrimSuffix(username, "\n")
 
-	logger.Info("parsed username", "username", username)
+	s.logger.Info("parsed username", "username", username)
+
+	return username, nil
+}
+
+func (s *Server) handleJoin(conn *conn, username string) error {
+	if err := s.claimName(conn, username); err != nil {
+		s.logger.Error("invalid username", "username", username, "error", err.Error(), "ip", conn.ip)
+		return err
+	}
+
+	s.activeConn[conn] = struct{}{}
+	s.enterRoom(conn, defaultRoom)
 
+	return nil
+}
+
+// claimName checks that username is 1 to 64 letters and digits and not
+// used by anyone else and makes it conn's, marking conn as joined. Both
+// joining and /nick go through it.
+func (s *Server) claimName(conn *conn, username string) error {
 	if username == "" {
-		logger.Error("Username is a new line", "ip", conn.ip)
 		return errors.New("username must be at least 1 character")
 	}
 
 	if len(username) > 64 {
-		logger.Error("username is too long", "username", username)
 		return fmt.Errorf("username %s is too long: max 64 chars", username)
 	}
 
-	for c := range s.activeConn {
-		if c == conn {
-			continue
-		}
+	if !reUsername.MatchString(username) {
+		return fmt.Errorf("username %q may only contain letters and digits", username)
+	}
 
-		if username == conn.username {
-			logger.Error("duplicate username", "username", c.username, "ip", c.ip)
-			return fmt.Errorf("* username %s is already taken", username)
-		}
+	if c := s.findUser(username); c != nil && c != conn {
+		return fmt.Errorf("* username %s is already taken", username)
 	}
 
 	conn.username = username
 	conn.joined = true
 
-	s.broadcast(conn, fmt.Sprintf("* %s has entered the room", conn.username))
-	s.sendMessage(conn, fmt.Sprintf("* The room contains: %s", s.getUsers(conn)))
-
 	return nil
 }
 
 func (s *Server) handleDisconnect(conn *conn) {
+	if !conn.joined {
+		return
+	}
+
+	s.leaveRoom(conn)
+
+	delete(s.activeConn, conn)
 	conn.joined = false
-	s.broadcast(conn, fmt.Sprintf("* %s has left the room", conn.username))
 }
 
 func (s *Server) handleData(conn *conn, data string) {
+	start := time.Now()
+
+	if strings.HasPrefix(data, "/") {
+		s.handleCommand(conn, data)
+		s.requests.Observe("command", start)
+		return
+	}
+
 	if strings.HasPrefix("*", data) {
 		return
 	}
-	s.broadcast(conn, fmt.Sprintf("[%s] %s", conn.username, data))
+
+	s.say(conn, fmt.Sprintf("[%s] %s", conn.username, data))
+	s.requests.Observe("message", start)
 }
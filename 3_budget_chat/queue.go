@@ -0,0 +1,119 @@
+package chat
+
+import (
+	"bufio"
+	"net"
+	"time"
+)
+
+const (
+	defaultQueueSize    = 256
+	defaultWriteTimeout = 10 * time.Second
+)
+
+// SlowConsumerPolicy decides what happens to a message for a client whose
+// outbound queue is full.
+type SlowConsumerPolicy int
+
+const (
+	// DropOldest discards the oldest queued message to make room.
+	DropOldest SlowConsumerPolicy = iota
+	// Disconnect closes the client's connection.
+	Disconnect
+)
+
+func (p SlowConsumerPolicy) String() string {
+	if p == Disconnect {
+		return "disconnect"
+	}
+
+	return "drop_oldest"
+}
+
+// WithOutboundQueue sets how many messages may wait to be written to a
+// single client and what to do once that many are waiting. The default is
+// 256 and DropOldest.
+func WithOutboundQueue(size int, policy SlowConsumerPolicy) ServerOpt {
+	return func(s *Server) {
+		s.queueSize = size
+		s.slowPolicy = policy
+	}
+}
+
+// WithWriteTimeout sets how long a write to a client may take before the
+// client is disconnected. The default is 10 seconds.
+func WithWriteTimeout(d time.Duration) ServerOpt {
+	return func(s *Server) {
+		s.writeTimeout = d
+	}
+}
+
+// sendMessage queues msg for c without waiting for it to be written, so a
+// client that doesn't read can't hold up whoever is talking to it.
+func (s *Server) sendMessage(c *conn, msg string) {
+	c.queueMu.Lock()
+	defer c.queueMu.Unlock()
+
+	if c.queueClosed {
+		return
+	}
+
+	select {
+	case c.queue <- msg:
+		return
+	default:
+	}
+
+	s.metrics.Counter("protohackers_slow_consumers_total", "Messages that found a client's outbound queue full.", "server", "chat", "policy", s.slowPolicy.String()).Inc()
+
+	if s.slowPolicy == Disconnect {
+		s.logger.Warn("disconnecting slow consumer", "ip", c.ip)
+		c.closeQueue()
+		c.close()
+		return
+	}
+
+	// only senders holding queueMu fill the queue, so there is room after
+	// taking one out, or after the writer did
+	select {
+	case <-c.queue:
+	default:
+	}
+
+	c.queue <- msg
+}
+
+// writeLoop writes c's queued messages until the queue is closed, flushing
+// whenever it catches up. A write that fails or times out closes the
+// connection, which ends the client's read loop.
+func (s *Server) writeLoop(c *conn) {
+	defer close(c.writerDone)
+
+	w := bufio.NewWriter(timeoutWriter{conn: c.rwc, timeout: s.writeTimeout})
+
+	for msg := range c.queue {
+		w.WriteString(msg + "\n")
+
+		if len(c.queue) > 0 {
+			continue
+		}
+
+		if err := w.Flush(); err != nil {
+			s.logger.Error("error writing to client", "error", err.Error(), "ip", c.ip)
+			c.close()
+			return
+		}
+	}
+}
+
+// timeoutWriter gives every write to conn a fresh deadline, including the
+// ones bufio makes by itself when a message doesn't fit its buffer.
+type timeoutWriter struct {
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (w timeoutWriter) Write(b []byte) (int, error) {
+	w.conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	return w.conn.Write(b)
+}
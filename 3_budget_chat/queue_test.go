@@ -0,0 +1,166 @@
+package chat
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bcatubig/protohackers/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+// flood sends enough long lines from c to fill a reader's socket buffers
+// many times over, then "done".
+func flood(c *testClient) {
+	c.t.Helper()
+
+	c.conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	line := strings.Repeat("x", 1000)
+
+	for range 20000 {
+		c.send(line)
+	}
+
+	c.send("done")
+}
+
+func TestSlowConsumerDisconnect(t *testing.T) {
+	reg := metrics.NewRegistry()
+	addr := startServer(t, WithMetrics(reg), WithOutboundQueue(4, Disconnect))
+
+	alice := join(t, addr, "alice")
+	join(t, addr, "slow")
+	alice.expect("* slow has entered the room")
+
+	flood(alice)
+
+	for {
+		if line := alice.read(); line == "* slow has left the room" {
+			break
+		}
+	}
+
+	assert.Positive(t, reg.Counter("protohackers_slow_consumers_total", "", "server", "chat", "policy", "disconnect").Value())
+}
+
+func TestSlowConsumerDropOldest(t *testing.T) {
+	reg := metrics.NewRegistry()
+	addr := startServer(t, WithMetrics(reg), WithOutboundQueue(4, DropOldest))
+
+	alice := join(t, addr, "alice")
+	slow := join(t, addr, "slow")
+	alice.expect("* slow has entered the room")
+
+	flood(alice)
+
+	// alice isn't held up by slow
+	alice.send("/who")
+	alice.expect("* In #lobby: alice slow")
+
+	slow.conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	received := 0
+
+	for slow.read() != "[alice] done" {
+		received++
+	}
+
+	assert.Less(t, received, 20000)
+	assert.Positive(t, reg.Counter("protohackers_slow_consumers_total", "", "server", "chat", "policy", "drop_oldest").Value())
+}
+
+func TestWriteTimeoutAfterIdle(t *testing.T) {
+	addr := startServer(t, WithWriteTimeout(200*time.Millisecond))
+
+	alice := join(t, addr, "alice")
+	bob := join(t, addr, "bob")
+	alice.expect("* bob has entered the room")
+
+	// longer than bufio's buffer, so it's written without a flush
+	line := strings.Repeat("x", 5000)
+
+	time.Sleep(500 * time.Millisecond)
+
+	alice.send(line)
+	bob.expect("[alice] " + line)
+}
+
+func TestBroadcastStress(t *testing.T) {
+	const (
+		clients  = 200
+		messages = 5
+	)
+
+	addr := startServer(t, WithOutboundQueue(4096, Disconnect))
+
+	joined := &sync.WaitGroup{}
+	done := &sync.WaitGroup{}
+	start := make(chan struct{})
+
+	received := make([]int, clients)
+
+	for i := range clients {
+		joined.Add(1)
+		done.Add(1)
+
+		go func() {
+			defer done.Done()
+
+			// join and read use require, which only the test goroutine may
+			conn, err := net.Dial("tcp", addr)
+			if !assert.NoError(t, err) {
+				joined.Done()
+				return
+			}
+			defer conn.Close()
+
+			conn.SetDeadline(time.Now().Add(30 * time.Second))
+			reader := bufio.NewReader(conn)
+
+			fmt.Fprintf(conn, "user%d\n", i)
+
+			for {
+				line, err := reader.ReadString('\n')
+				if !assert.NoError(t, err) {
+					joined.Done()
+					return
+				}
+
+				if strings.HasPrefix(line, "* The room contains:") {
+					break
+				}
+			}
+
+			joined.Done()
+			<-start
+
+			for j := range messages {
+				fmt.Fprintf(conn, "message %d\n", j)
+			}
+
+			for received[i] < (clients-1)*messages {
+				line, err := reader.ReadString('\n')
+				if !assert.NoError(t, err, "user%d", i) {
+					return
+				}
+
+				if strings.HasPrefix(line, "[") {
+					received[i]++
+				}
+			}
+		}()
+	}
+
+	joined.Wait()
+	close(start)
+	done.Wait()
+
+	for i, n := range received {
+		assert.Equal(t, (clients-1)*messages, n, "user%d", i)
+	}
+}
@@ -0,0 +1,116 @@
+package chat
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// defaultRoom is where users land when they join and when they /part.
+const defaultRoom = "#lobby"
+
+var reRoomName = regexp.MustCompile(`^#[A-Za-z0-9_-]{1,32}$`)
+
+// roomConns returns the joined conns in room.
+func (s *Server) roomConns(room string) []*conn {
+	var conns []*conn
+
+	for c := range s.activeConn {
+		if c.joined && c.room == room {
+			conns = append(conns, c)
+		}
+	}
+
+	return conns
+}
+
+// members returns the sorted usernames of everyone in c's room but c.
+func (s *Server) members(c *conn) []string {
+	var names []string
+
+	for cn := range s.activeConn {
+		if cn != c && cn.joined && cn.room == c.room {
+			names = append(names, cn.username)
+		}
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// enterRoom moves c into room, announcing it to the room and telling c who
+// is there and, with history on, what was said recently.
+func (s *Server) enterRoom(c *conn, room string) {
+	c.room = room
+
+	s.broadcast(c, fmt.Sprintf("* %s has entered the room", c.username))
+	s.sendMessage(c, fmt.Sprintf("* The room contains: %s", strings.Join(s.members(c), " ")))
+
+	if s.history == nil {
+		return
+	}
+
+	for _, msg := range s.history.recent(room) {
+		s.sendMessage(c, msg)
+	}
+}
+
+// leaveRoom announces to c's room that c has left it.
+func (s *Server) leaveRoom(c *conn) {
+	s.broadcast(c, fmt.Sprintf("* %s has left the room", c.username))
+}
+
+func cmdJoin(s *Server, c *conn, arg string) {
+	if !reRoomName.MatchString(arg) {
+		s.sendMessage(c, "* Room names start with # followed by up to 32 letters, digits, _ or -")
+		return
+	}
+
+	if arg == c.room {
+		s.sendMessage(c, fmt.Sprintf("* You are already in %s", arg))
+		return
+	}
+
+	s.switchRoom(c, arg)
+}
+
+func cmdPart(s *Server, c *conn, _ string) {
+	if c.room == defaultRoom {
+		s.sendMessage(c, fmt.Sprintf("* You are already in %s", defaultRoom))
+		return
+	}
+
+	s.switchRoom(c, defaultRoom)
+}
+
+func cmdRooms(s *Server, c *conn, _ string) {
+	s.sendMessage(c, fmt.Sprintf("* Rooms: %s", s.roomList()))
+}
+
+func (s *Server) switchRoom(c *conn, room string) {
+	s.leaveRoom(c)
+	s.sendMessage(c, fmt.Sprintf("* You are now in %s", room))
+	s.enterRoom(c, room)
+}
+
+// roomList describes every room with someone in it, for /rooms.
+func (s *Server) roomList() string {
+	counts := map[string]int{}
+
+	for c := range s.activeConn {
+		if c.joined {
+			counts[c.room]++
+		}
+	}
+
+	var rooms []string
+
+	for _, room := range slices.Sorted(maps.Keys(counts)) {
+		rooms = append(rooms, fmt.Sprintf("%s (%d)", room, counts[room]))
+	}
+
+	return strings.Join(rooms, ", ")
+}
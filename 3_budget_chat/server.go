@@ -1,140 +1,234 @@
-package main
+package chat
 
 import (
-	"bufio"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"net"
+	"net/http"
+	"os"
 	"strings"
 	"sync"
-	"sync/atomic"
+	"time"
+
+	"github.com/bcatubig/protohackers/metrics"
+	"github.com/bcatubig/protohackers/server"
 )
 
 type Server struct {
-	l          net.Listener
-	inShutdown atomic.Bool
+	*server.Server
+
+	// activeConn holds the joined conns. It belongs to the hub.
 	activeConn map[*conn]struct{}
 	clientMsgs chan clientMessage
-	mu         sync.Mutex
+	quit       chan struct{}
+	stopHub    sync.Once
+
+	logger   *slog.Logger
+	metrics  *metrics.Registry
+	requests *metrics.Requests
+
+	queueSize    int
+	slowPolicy   SlowConsumerPolicy
+	writeTimeout time.Duration
+
+	historyDepth int
+	historyDir   string
+	history      *history
+
+	wsAddr     string
+	wsListener net.Listener
+	ws         *http.Server
 }
 
-func NewServer(addr string) (*Server, error) {
-	l, err := net.Listen("tcp", addr)
+type ServerOpt func(s *Server)
 
-	if err != nil {
-		return nil, err
+func WithLogger(l *slog.Logger) ServerOpt {
+	return func(s *Server) {
+		s.logger = l
 	}
+}
 
-	return &Server{
-		l:          l,
+func WithMetrics(r *metrics.Registry) ServerOpt {
+	return func(s *Server) {
+		s.metrics = r
+	}
+}
+
+// WithHistory replays the last depth messages of a room to everyone who
+// enters it. Off by default, as the challenge's checker doesn't expect it.
+func WithHistory(depth int) ServerOpt {
+	return func(s *Server) {
+		s.historyDepth = depth
+	}
+}
+
+// WithHistoryJournal keeps each room's history in a file in dir so it
+// survives restarts. It has no effect without WithHistory.
+func WithHistoryJournal(dir string) ServerOpt {
+	return func(s *Server) {
+		s.historyDir = dir
+	}
+}
+
+func NewServer(addr string, opts ...ServerOpt) (*Server, error) {
+	s := &Server{
 		activeConn: make(map[*conn]struct{}),
 		clientMsgs: make(chan clientMessage),
-		mu:         sync.Mutex{},
-	}, nil
-}
+		quit:       make(chan struct{}),
 
-func (s *Server) ListenAndServe() error {
-	for {
-		c, err := s.l.Accept()
+		queueSize:    defaultQueueSize,
+		writeTimeout: defaultWriteTimeout,
+	}
 
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.logger == nil {
+		s.logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
+
+	if s.metrics == nil {
+		s.metrics = metrics.NewRegistry()
+	}
+
+	s.requests = s.metrics.Requests("chat")
+
+	if s.historyDepth > 0 {
+		h, err := newHistory(s.historyDepth, s.historyDir)
 		if err != nil {
-			logger.Error("connection error", "error", err.Error())
-			continue
+			return nil, fmt.Errorf("loading history: %w", err)
 		}
 
-		nConn := &conn{ip: c.RemoteAddr().String(), rwc: c}
+		s.history = h
+	}
 
-		s.addConn(nConn)
-		go s.handle(nConn)
+	if s.wsAddr != "" {
+		if err := s.listenWebSocket(); err != nil {
+			return nil, fmt.Errorf("listening for websockets: %w", err)
+		}
 	}
+
+	srv, err := server.New(addr, server.HandlerFunc(s.handle), server.WithLogger(s.logger), server.WithMetrics(s.metrics, "chat"))
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.Server = srv
+
+	go s.run()
+
+	return s, nil
 }
 
+// Shutdown stops the server, the WebSocket listener and the hub and closes
+// the history journal.
 func (s *Server) Shutdown(ctx context.Context) error {
-	s.inShutdown.Store(true)
+	var err error
 
-	logger.Info("shutting down server")
+	if s.ws != nil {
+		err = s.ws.Shutdown(ctx)
+	}
 
-	chanDone := make(chan struct{})
+	err = errors.Join(err, s.Server.Shutdown(ctx))
 
-	go func() {
-		for c := range s.activeConn {
-			c.close()
-			s.removeConn(c)
-		}
-		chanDone <- struct{}{}
-	}()
+	s.stopHub.Do(func() { close(s.quit) })
 
-	select {
-	case <-ctx.Done():
-		logger.Error("timed out waiting for server to shutdown")
-	case <-chanDone:
-		logger.Info("shutdown successful")
+	if s.history != nil {
+		err = errors.Join(err, s.history.close())
 	}
 
-	return nil
+	return err
 }
 
-func (s *Server) broadcast(c *conn, msg string) {
-	logger.Info("broadcasting message", "msg", msg)
-	wg := &sync.WaitGroup{}
-
-	for cn := range s.activeConn {
-		if cn == c || !cn.joined {
-			continue
+// say sends msg to everyone else in c's room and records it in the room's
+// history.
+func (s *Server) say(c *conn, msg string) {
+	if s.history != nil {
+		if err := s.history.add(c.room, msg); err != nil {
+			s.logger.Error("error recording history", "room", c.room, "error", err.Error())
 		}
+	}
 
-		wg.Add(1)
+	s.broadcast(c, msg)
+}
 
-		go func() {
-			defer wg.Done()
+// broadcast queues msg for every other joined conn in c's room.
+func (s *Server) broadcast(c *conn, msg string) {
+	s.logger.Info("broadcasting message", "room", c.room, "msg", msg)
+
+	for _, cn := range s.roomConns(c.room) {
+		if cn != c {
 			s.sendMessage(cn, msg)
-		}()
+		}
 	}
-
-	wg.Wait()
 }
 
-func (s *Server) handle(c *conn) {
+func (s *Server) handle(ctx context.Context, rwc net.Conn) {
+	c := newConn(rwc, max(s.queueSize, 1))
+
+	go s.writeLoop(c)
+
 	defer func() {
-		s.removeConn(c)
+		s.logger.Info("closing connection", "ip", c.ip)
+
+		c.queueMu.Lock()
+		c.closeQueue()
+		c.queueMu.Unlock()
+
+		<-c.writerDone
 		c.close()
 	}()
 
-	logger.Info("client connected", "ip", c.ip)
+	s.logger.Info("client connected", "ip", c.ip)
 
 	// header
 	s.sendMessage(c, "Welcome to budgetchat! What shall I call you?")
 
-	err := s.handleJoin(c)
+	username, err := s.readUsername(c)
+
+	if err == nil {
+		result := make(chan error, 1)
+
+		if !s.post(clientMessage{msgType: clientJoined, conn: c, data: username, result: result}) {
+			return
+		}
+
+		err = <-result
+	}
 
 	if err != nil {
-		logger.Error("client failed to join", "error", err.Error())
+		s.logger.Error("client failed to join", "error", err.Error())
+		s.requests.Malformed()
 		s.sendMessage(c, err.Error())
 		return
 	}
 
+	// runs before the queue is closed, and the hub has handled everything
+	// this client sent once it takes this
+	defer s.post(clientMessage{msgType: clientDisconnected, conn: c})
+
 	for {
-		if s.inShutdown.Load() {
+		if ctx.Err() != nil {
 			return
 		}
 
-		buf := bufio.NewReaderSize(c.rwc, 2048)
-
-		line, err := buf.ReadString('\n')
+		line, err := c.reader.ReadString('\n')
 		line = strings.TrimSuffix(line, "\n")
 		line = strings.TrimSuffix(line, "\r")
 
 		if err != nil {
 			if errors.Is(err, io.EOF) {
-				logger.Info("client disconnected", "username", c.username, "ip", c.ip)
-				s.handleDisconnect(c)
+				s.logger.Info("client disconnected", "ip", c.ip)
 				return
 			}
 
-			logger.Error(err.Error())
-			continue
+			s.logger.Error("error reading from client", "error", err.Error(), "ip", c.ip)
+			return
 		}
 
 		// Don't print empty lines
@@ -143,43 +237,8 @@ func (s *Server) handle(c *conn) {
 			continue
 		}
 
-		// Send this line to all clients except current client
-		s.handleData(c, line)
-	}
-}
-
-func (s *Server) getUsers(c *conn) string {
-	var result []string
-
-	logger.Info("getting users", "ip", c.ip)
-
-	for cn := range s.activeConn {
-		if cn.username == c.username {
-			continue
-		}
-
-		if !c.joined {
-			continue
+		if !s.post(clientMessage{msgType: clientData, conn: c, data: line}) {
+			return
 		}
-
-		result = append(result, cn.username)
 	}
-
-	return strings.Join(result, " ")
-}
-
-func (s *Server) addConn(c *conn) {
-	s.mu.Lock()
-	s.activeConn[c] = struct{}{}
-	s.mu.Unlock()
-}
-
-func (s *Server) removeConn(c *conn) {
-	s.mu.Lock()
-	delete(s.activeConn, c)
-	s.mu.Unlock()
-}
-
-func (s *Server) sendMessage(conn *conn, msg string) {
-	io.Copy(conn, strings.NewReader(fmt.Sprintf("%s\n", msg)))
 }
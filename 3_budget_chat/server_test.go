@@ -0,0 +1,207 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func startServer(t *testing.T, opts ...ServerOpt) string {
+	t.Helper()
+
+	s, err := NewServer("127.0.0.1:0", append([]ServerOpt{WithLogger(slog.New(slog.DiscardHandler))}, opts...)...)
+	require.NoError(t, err)
+
+	go s.ListenAndServe()
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		s.Shutdown(ctx)
+	})
+
+	return s.Addr().String()
+}
+
+// join connects as name and reads up to and including the room listing.
+func join(t *testing.T, addr, name string) *testClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	c := &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+
+	c.expect("Welcome to budgetchat! What shall I call you?")
+	c.send(name)
+
+	line := c.read()
+	require.True(t, strings.HasPrefix(line, "* The room contains:"), line)
+
+	return c
+}
+
+func (c *testClient) send(line string) {
+	c.t.Helper()
+
+	_, err := c.conn.Write([]byte(line + "\n"))
+	require.NoError(c.t, err)
+}
+
+func (c *testClient) read() string {
+	c.t.Helper()
+
+	line, err := c.reader.ReadString('\n')
+	require.NoError(c.t, err)
+
+	return strings.TrimSuffix(line, "\n")
+}
+
+func (c *testClient) expect(want string) {
+	c.t.Helper()
+
+	assert.Equal(c.t, want, c.read())
+}
+
+func TestRooms(t *testing.T) {
+	addr := startServer(t)
+
+	alice := join(t, addr, "alice")
+	bob := join(t, addr, "bob")
+	alice.expect("* bob has entered the room")
+
+	alice.send("/join #go")
+	alice.expect("* You are now in #go")
+	alice.expect("* The room contains: ")
+	bob.expect("* alice has left the room")
+
+	carol := join(t, addr, "carol")
+	bob.expect("* carol has entered the room")
+
+	carol.send("/join #go")
+	carol.expect("* You are now in #go")
+	carol.expect("* The room contains: alice")
+	alice.expect("* carol has entered the room")
+	bob.expect("* carol has left the room")
+
+	// messages stay in their room
+	carol.send("hi gophers")
+	alice.expect("[carol] hi gophers")
+
+	bob.send("/rooms")
+	bob.expect("* Rooms: #go (2), #lobby (1)")
+
+	alice.send("/part")
+	alice.expect("* You are now in #lobby")
+	alice.expect("* The room contains: bob")
+	carol.expect("* alice has left the room")
+	bob.expect("* alice has entered the room")
+
+	bob.send("hello lobby")
+	alice.expect("[bob] hello lobby")
+}
+
+func TestCommands(t *testing.T) {
+	addr := startServer(t)
+
+	alice := join(t, addr, "alice")
+	bob := join(t, addr, "bob")
+	alice.expect("* bob has entered the room")
+
+	bob.send("/who")
+	bob.expect("* In #lobby: alice bob")
+
+	alice.send("/msg bob psst")
+	bob.expect("[alice -> you] psst")
+	alice.expect("[you -> bob] psst")
+
+	alice.send("/msg carol psst")
+	alice.expect("* No such user: carol")
+
+	alice.send("/msg bob")
+	alice.expect("* Usage: /msg <user> <text>")
+
+	alice.send("/me waves")
+	bob.expect("* alice waves")
+
+	bob.send("/nick a b")
+	bob.expect(`* Can't change name: username "a b" may only contain letters and digits`)
+
+	bob.send("/nick alice")
+	bob.expect("* Can't change name: username alice is already taken")
+
+	bob.send("/nick robert")
+	bob.expect("* You are now known as robert")
+	alice.expect("* bob is now known as robert")
+
+	alice.send("/msg robert hi robert")
+	bob.expect("[alice -> you] hi robert")
+	alice.expect("[you -> robert] hi robert")
+
+	// unknown commands are only answered to the sender
+	alice.send("/dance")
+	alice.expect("* Unknown command: /dance, try /help")
+	bob.send("still here")
+	alice.expect("[robert] still here")
+}
+
+func TestJoinDuplicateName(t *testing.T) {
+	addr := startServer(t)
+
+	join(t, addr, "alice")
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	c := &testClient{t: t, conn: conn, reader: bufio.NewReader(conn)}
+	c.expect("Welcome to budgetchat! What shall I call you?")
+	c.send("alice")
+	c.expect("* username alice is already taken")
+
+	_, err = c.reader.ReadString('\n')
+	assert.Error(t, err)
+}
+
+func TestHistoryReplay(t *testing.T) {
+	addr := startServer(t, WithHistory(2))
+
+	alice := join(t, addr, "alice")
+	alice.send("one")
+	alice.send("two")
+	alice.send("/me waves")
+
+	alice.send("/join #go")
+	alice.expect("* You are now in #go")
+	alice.expect("* The room contains: ")
+	alice.send("gophers?")
+
+	// only what was said, and only the last two lines of it
+	bob := join(t, addr, "bob")
+	bob.expect("[alice] two")
+	bob.expect("* alice waves")
+
+	bob.send("/join #go")
+	bob.expect("* You are now in #go")
+	bob.expect("* The room contains: alice")
+	bob.expect("[alice] gophers?")
+}
@@ -0,0 +1,411 @@
+package chat
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is appended to a client's key to prove the server speaks
+// WebSocket, per RFC 6455.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// readHeaderTimeout bounds how long a client may take to send the upgrade
+// request's headers.
+const readHeaderTimeout = 10 * time.Second
+
+// maxMessageSize bounds a single message from a browser, fragments included.
+const maxMessageSize = 64 << 10
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close codes sent to a browser before closing its socket.
+const (
+	closeNormal        = 1000
+	closeProtocolError = 1002
+	closeTooBig        = 1009
+)
+
+var (
+	errNotMasked   = errors.New("websocket: client frame is not masked")
+	errReserved    = errors.New("websocket: reserved bits set without an extension")
+	errBadControl  = errors.New("websocket: fragmented or oversized control frame")
+	errBadFragment = errors.New("websocket: unexpected continuation frame")
+	errTooBig      = errors.New("websocket: message too big")
+)
+
+// WithWebSocket also accepts browsers on addr. Requests there are upgraded
+// to WebSocket and served just like TCP clients, one line per text message,
+// in the same rooms.
+func WithWebSocket(addr string) ServerOpt {
+	return func(s *Server) {
+		s.wsAddr = addr
+	}
+}
+
+// listenWebSocket starts listening on wsAddr. Connections aren't accepted
+// until ListenAndServe.
+func (s *Server) listenWebSocket() error {
+	l, err := net.Listen("tcp", s.wsAddr)
+	if err != nil {
+		return err
+	}
+
+	s.wsListener = l
+	s.ws = &http.Server{
+		Handler:           http.HandlerFunc(s.serveWebSocket),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	return nil
+}
+
+// WebSocketAddr returns the address browsers connect to, or nil without
+// WithWebSocket.
+func (s *Server) WebSocketAddr() net.Addr {
+	if s.wsListener == nil {
+		return nil
+	}
+
+	return s.wsListener.Addr()
+}
+
+// ListenAndServe accepts TCP clients and, with WithWebSocket, browsers until
+// Shutdown.
+func (s *Server) ListenAndServe() error {
+	if s.ws != nil {
+		go func() {
+			err := s.ws.Serve(s.wsListener)
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				s.logger.Error("websocket server error", "error", err.Error())
+			}
+		}()
+	}
+
+	return s.Server.ListenAndServe()
+}
+
+// serveWebSocket upgrades r and hands the socket to the TCP server, which
+// runs the usual handler on it until the browser goes away.
+func (s *Server) serveWebSocket(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+
+	if r.Method != http.MethodGet ||
+		!headerHas(r.Header, "Connection", "upgrade") ||
+		!headerHas(r.Header, "Upgrade", "websocket") ||
+		r.Header.Get("Sec-WebSocket-Version") != "13" ||
+		key == "" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+		return
+	}
+
+	rwc, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		s.logger.Error("error hijacking websocket connection", "error", err.Error())
+		http.Error(w, "websocket upgrade failed", http.StatusInternalServerError)
+		return
+	}
+
+	// the http server's deadlines don't apply to the socket
+	rwc.SetDeadline(time.Time{})
+
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", acceptKey(key))
+
+	if err := brw.Flush(); err != nil {
+		rwc.Close()
+		return
+	}
+
+	s.logger.Info("websocket connected", "ip", rwc.RemoteAddr().String())
+
+	if err := s.Server.Serve(newWSConn(rwc, brw.Reader)); err != nil {
+		s.logger.Info("websocket refused", "error", err.Error())
+	}
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// headerHas reports whether the comma separated header name lists token.
+func headerHas(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for t := range strings.SplitSeq(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// wsConn makes a WebSocket look like the newline separated stream TCP
+// clients send: each message read ends in a newline, and every line
+// written goes out as a text message of its own.
+type wsConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	// unread is what's left of the last message
+	unread []byte
+	// partial is written data not yet ended by a newline
+	partial []byte
+
+	// writeMu keeps pongs from the reader apart from writes
+	writeMu sync.Mutex
+	closed  bool
+}
+
+func newWSConn(c net.Conn, reader *bufio.Reader) *wsConn {
+	return &wsConn{Conn: c, reader: reader}
+}
+
+func (c *wsConn) Read(b []byte) (int, error) {
+	for len(c.unread) == 0 {
+		msg, err := c.readMessage()
+		if err != nil {
+			return 0, err
+		}
+
+		c.unread = append(msg, '\n')
+	}
+
+	n := copy(b, c.unread)
+	c.unread = c.unread[n:]
+
+	return n, nil
+}
+
+// readMessage returns the next text or binary message, answering pings
+// and closes on the way. A close from the browser reads as io.EOF.
+func (c *wsConn) readMessage() ([]byte, error) {
+	var msg []byte
+
+	inMessage := false
+
+	for {
+		fin, op, payload, err := c.readFrame()
+
+		switch {
+		case errors.Is(err, errTooBig):
+			c.writeClose(closeTooBig)
+		case errors.Is(err, errNotMasked), errors.Is(err, errReserved), errors.Is(err, errBadControl):
+			c.writeClose(closeProtocolError)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeClose(closeNormal)
+			return nil, io.EOF
+		case opText, opBinary:
+			if inMessage {
+				c.writeClose(closeProtocolError)
+				return nil, errBadFragment
+			}
+
+			inMessage = true
+		case opContinuation:
+			if !inMessage {
+				c.writeClose(closeProtocolError)
+				return nil, errBadFragment
+			}
+		default:
+			c.writeClose(closeProtocolError)
+			return nil, fmt.Errorf("websocket: unknown opcode %#x", op)
+		}
+
+		if len(msg)+len(payload) > maxMessageSize {
+			c.writeClose(closeTooBig)
+			return nil, errTooBig
+		}
+
+		msg = append(msg, payload...)
+
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// readFrame reads and unmasks a single frame.
+func (c *wsConn) readFrame() (bool, byte, []byte, error) {
+	var header [2]byte
+
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	op := header[0] & 0x0f
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+
+	if !masked {
+		return false, 0, nil, errNotMasked
+	}
+
+	// RSV1-3 are only for extensions, and none are negotiated
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, errReserved
+	}
+
+	if op >= opClose && (!fin || length > 125) {
+		return false, 0, nil, errBadControl
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+
+		if _, err := io.ReadFull(c.reader, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+
+	if length > maxMessageSize {
+		return false, 0, nil, errTooBig
+	}
+
+	var mask [4]byte
+
+	if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+
+	payload := make([]byte, length)
+
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+
+	return fin, op, payload, nil
+}
+
+// Write sends every complete line in b as a text message, keeping the rest
+// for the next call.
+func (c *wsConn) Write(b []byte) (int, error) {
+	c.partial = append(c.partial, b...)
+
+	for {
+		i := slices.Index(c.partial, '\n')
+		if i < 0 {
+			break
+		}
+
+		// browsers drop the connection on text that isn't UTF-8
+		line := strings.ToValidUTF8(string(c.partial[:i]), "\uFFFD")
+		c.partial = c.partial[i+1:]
+
+		if err := c.writeFrame(opText, []byte(line)); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+func (c *wsConn) writeFrame(op byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	return c.writeFrameLocked(op, payload)
+}
+
+func (c *wsConn) writeFrameLocked(op byte, payload []byte) error {
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	frame := make([]byte, 0, len(payload)+10)
+	frame = append(frame, 0x80|op)
+
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, byte(n))
+	case n <= 0xffff:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+
+	frame = append(frame, payload...)
+
+	_, err := c.Conn.Write(frame)
+
+	return err
+}
+
+// writeClose sends a close frame with code, after which nothing else is
+// written.
+func (c *wsConn) writeClose(code uint16) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.writeCloseLocked(code)
+}
+
+func (c *wsConn) writeCloseLocked(code uint16) {
+	c.writeFrameLocked(opClose, binary.BigEndian.AppendUint16(nil, code))
+	c.closed = true
+}
+
+// Close says goodbye to the browser, if nobody did yet, and closes the
+// socket. It never waits on a write in progress, which may be stuck on a
+// browser that stopped reading; that browser goes without a goodbye.
+func (c *wsConn) Close() error {
+	if c.writeMu.TryLock() {
+		c.Conn.SetWriteDeadline(time.Now().Add(time.Second))
+		c.writeCloseLocked(closeNormal)
+		c.writeMu.Unlock()
+	}
+
+	return c.Conn.Close()
+}
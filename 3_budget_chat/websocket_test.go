@@ -0,0 +1,240 @@
+package chat
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// wsClient is just enough of a browser to talk to the gateway.
+type wsClient struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialWS(t *testing.T, addr string) *wsClient {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	const key = "dGhlIHNhbXBsZSBub25jZQ=="
+
+	fmt.Fprintf(conn, "GET /chat HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Version: 13\r\n\r\n", addr, key)
+
+	reader := bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(reader, nil)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	// the example from RFC 6455
+	require.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", resp.Header.Get("Sec-WebSocket-Accept"))
+
+	return &wsClient{t: t, conn: conn, reader: reader}
+}
+
+func (c *wsClient) writeFrame(fin bool, op byte, payload []byte) {
+	c.t.Helper()
+
+	first := op
+	if fin {
+		first |= 0x80
+	}
+
+	frame := []byte{first}
+
+	switch n := len(payload); {
+	case n <= 125:
+		frame = append(frame, 0x80|byte(n))
+	default:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	}
+
+	mask := [4]byte{1, 2, 3, 4}
+	frame = append(frame, mask[:]...)
+
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	_, err := c.conn.Write(frame)
+	require.NoError(c.t, err)
+}
+
+func (c *wsClient) send(msg string) {
+	c.writeFrame(true, opText, []byte(msg))
+}
+
+func (c *wsClient) readFrame() (byte, []byte) {
+	c.t.Helper()
+
+	var header [2]byte
+
+	_, err := io.ReadFull(c.reader, header[:])
+	require.NoError(c.t, err)
+
+	require.Zero(c.t, header[1]&0x80, "server frames must not be masked")
+
+	length := int(header[1] & 0x7f)
+
+	if length == 126 {
+		var ext [2]byte
+		_, err := io.ReadFull(c.reader, ext[:])
+		require.NoError(c.t, err)
+
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+
+	payload := make([]byte, length)
+	_, err = io.ReadFull(c.reader, payload)
+	require.NoError(c.t, err)
+
+	return header[0] & 0x0f, payload
+}
+
+func (c *wsClient) expect(want string) {
+	c.t.Helper()
+
+	op, payload := c.readFrame()
+	assert.Equal(c.t, byte(opText), op)
+	assert.Equal(c.t, want, string(payload))
+}
+
+func startWSServer(t *testing.T) *Server {
+	t.Helper()
+
+	s, err := NewServer("127.0.0.1:0", WithLogger(slog.New(slog.DiscardHandler)), WithWebSocket("127.0.0.1:0"))
+	require.NoError(t, err)
+
+	go s.ListenAndServe()
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		s.Shutdown(ctx)
+	})
+
+	return s
+}
+
+func TestWebSocketBridge(t *testing.T) {
+	s := startWSServer(t)
+
+	alice := join(t, s.Addr().String(), "alice")
+
+	bob := dialWS(t, s.WebSocketAddr().String())
+	bob.expect("Welcome to budgetchat! What shall I call you?")
+	bob.send("bob")
+	bob.expect("* The room contains: alice")
+	alice.expect("* bob has entered the room")
+
+	bob.send("hi from the browser")
+	alice.expect("[bob] hi from the browser")
+
+	alice.send("hi from the terminal")
+	bob.expect("[alice] hi from the terminal")
+
+	// fragmented, with a ping in the middle
+	bob.writeFrame(false, opText, []byte("frag"))
+	bob.writeFrame(true, opPing, []byte("are you there"))
+	bob.writeFrame(true, opContinuation, []byte("mented"))
+
+	op, payload := bob.readFrame()
+	assert.Equal(t, byte(opPong), op)
+	assert.Equal(t, "are you there", string(payload))
+
+	alice.expect("[bob] fragmented")
+
+	bob.send("/who")
+	bob.expect("* In #lobby: alice bob")
+
+	bob.writeFrame(true, opClose, binary.BigEndian.AppendUint16(nil, closeNormal))
+
+	op, payload = bob.readFrame()
+	assert.Equal(t, byte(opClose), op)
+	assert.Equal(t, uint16(closeNormal), binary.BigEndian.Uint16(payload))
+
+	alice.expect("* bob has left the room")
+}
+
+func TestWebSocketRejects(t *testing.T) {
+	wsAddr := startWSServer(t).WebSocketAddr().String()
+
+	t.Run("plain http", func(t *testing.T) {
+		resp, err := http.Get("http://" + wsAddr + "/chat")
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("unmasked frame", func(t *testing.T) {
+		c := dialWS(t, wsAddr)
+		c.expect("Welcome to budgetchat! What shall I call you?")
+
+		c.conn.Write([]byte{0x80 | opText, 3, 'b', 'o', 'b'})
+
+		op, payload := c.readFrame()
+		assert.Equal(t, byte(opClose), op)
+		assert.Equal(t, uint16(closeProtocolError), binary.BigEndian.Uint16(payload))
+	})
+
+	t.Run("reserved bits", func(t *testing.T) {
+		c := dialWS(t, wsAddr)
+		c.expect("Welcome to budgetchat! What shall I call you?")
+
+		// RSV1, as a compressed frame would have
+		c.writeFrame(true, 0x40|opText, []byte("bob"))
+
+		op, payload := c.readFrame()
+		assert.Equal(t, byte(opClose), op)
+		assert.Equal(t, uint16(closeProtocolError), binary.BigEndian.Uint16(payload))
+	})
+
+	t.Run("message too big", func(t *testing.T) {
+		c := dialWS(t, wsAddr)
+		c.expect("Welcome to budgetchat! What shall I call you?")
+
+		chunk := make([]byte, 60000)
+		c.writeFrame(false, opText, chunk)
+		c.writeFrame(true, opContinuation, chunk)
+
+		op, payload := c.readFrame()
+		assert.Equal(t, byte(opClose), op)
+		assert.Equal(t, uint16(closeTooBig), binary.BigEndian.Uint16(payload))
+	})
+}
+
+func TestWebSocketShutdown(t *testing.T) {
+	s := startWSServer(t)
+
+	c := dialWS(t, s.WebSocketAddr().String())
+	c.expect("Welcome to budgetchat! What shall I call you?")
+	c.send("carol")
+	c.expect("* The room contains: ")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	require.NoError(t, s.Shutdown(ctx))
+
+	op, _ := c.readFrame()
+	assert.Equal(t, byte(opClose), op)
+}
@@ -1,4 +1,4 @@
-package main
+package kv
 
 import "net"
 
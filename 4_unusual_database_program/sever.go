@@ -1,14 +1,19 @@
-package main
+package kv
 
 import (
 	"bytes"
 	"context"
 	"fmt"
+	"log/slog"
 	"net"
+	"os"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/bcatubig/protohackers/metrics"
+	"github.com/bcatubig/protohackers/server"
 	"github.com/tidwall/btree"
 )
 
@@ -19,9 +24,29 @@ type Server struct {
 	chanDone       chan struct{}
 
 	mu sync.Mutex
+
+	logger   *slog.Logger
+	metrics  *metrics.Registry
+	requests *metrics.Requests
+	bytesIn  *metrics.Counter
+	bytesOut *metrics.Counter
+}
+
+type ServerOpt func(s *Server)
+
+func WithLogger(l *slog.Logger) ServerOpt {
+	return func(s *Server) {
+		s.logger = l
+	}
 }
 
-func NewServer(addr string) (*Server, error) {
+func WithMetrics(r *metrics.Registry) ServerOpt {
+	return func(s *Server) {
+		s.metrics = r
+	}
+}
+
+func NewServer(addr string, opts ...ServerOpt) (*Server, error) {
 	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
 		return nil, err
@@ -41,33 +66,56 @@ func NewServer(addr string) (*Server, error) {
 		chanDone: make(chan struct{}),
 	}
 
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.logger == nil {
+		s.logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
+
+	if s.metrics == nil {
+		s.metrics = metrics.NewRegistry()
+	}
+
+	s.requests = s.metrics.Requests("kv")
+	s.bytesIn = s.metrics.Counter("protohackers_bytes_received_total", "Bytes read from clients.", "server", "kv")
+	s.bytesOut = s.metrics.Counter("protohackers_bytes_sent_total", "Bytes written to clients.", "server", "kv")
+
 	return s, nil
 }
 
-func (s *Server) ListenAndServe() {
-	logger.Info("listening for udp connections")
+func (s *Server) ListenAndServe() error {
+	s.logger.Info("listening for udp connections")
 
 	for {
 		if s.isShuttingDown.Load() {
-			logger.Info("server is shutting down. exiting")
-			return
+			s.logger.Info("server is shutting down. exiting")
+			return server.ErrServerClosed
 		}
 
 		buf := make([]byte, 1024)
 
 		n, addr, err := s.l.ReadFromUDP(buf)
 		if err != nil {
-			logger.Error(err.Error())
+			if s.isShuttingDown.Load() {
+				continue
+			}
+
+			s.logger.Error(err.Error())
 			continue
 		}
 
-		logger.Info("raw message", "data", string(buf[:n]))
+		s.bytesIn.Add(float64(n))
+
+		s.logger.Info("raw message", "data", string(buf[:n]))
 
 		bb := bytes.NewBuffer(buf)
 
 		data, err := bb.ReadString('\x00')
 		if err != nil {
-			logger.Error(err.Error())
+			s.logger.Error(err.Error())
+			s.requests.Malformed()
 			continue
 		}
 
@@ -79,30 +127,36 @@ func (s *Server) ListenAndServe() {
 			data: data,
 		}
 
-		logger.Info("got message", "addr", addr.String(), "length", n, "msg", client.data)
+		s.logger.Info("got message", "addr", addr.String(), "length", n, "msg", client.data)
 
 		go s.handle(client)
 	}
 }
 
 func (s *Server) Shutdown(ctx context.Context) error {
-	logger.Info("shutting down server")
+	s.logger.Info("shutting down server")
 	s.isShuttingDown.Store(true)
-	logger.Info("killing udp connection")
+	s.logger.Info("killing udp connection")
 	s.l.Close()
 	return nil
 }
 
 func (s *Server) handle(c *client) {
+	start := time.Now()
+
 	if strings.Contains(c.data, "version") {
 		s.handleVersion(c)
+		s.requests.Observe("version", start)
 	} else if strings.Contains(c.data, "=") {
 		s.handleInsert(c)
+		s.requests.Observe("insert", start)
 	} else {
 		s.handleRetrieve(c)
+		s.requests.Observe("retrieve", start)
 	}
 }
 
 func (s *Server) sendData(c *client, data string) {
-	s.l.WriteToUDP([]byte(fmt.Sprintf("%s", data)), c.addr)
+	n, _ := s.l.WriteToUDP([]byte(fmt.Sprintf("%s", data)), c.addr)
+	s.bytesOut.Add(float64(n))
 }
@@ -1,15 +1,19 @@
-package main
+package mitm
 
 import (
 	"bufio"
 	"context"
 	"errors"
 	"io"
+	"log/slog"
 	"net"
+	"os"
 	"regexp"
 	"strings"
-	"sync"
-	"sync/atomic"
+	"time"
+
+	"github.com/bcatubig/protohackers/metrics"
+	"github.com/bcatubig/protohackers/server"
 )
 
 const (
@@ -19,59 +23,64 @@ const (
 var reWalletAddress = regexp.MustCompile(`(7[a-zA-Z0-9]{25,34}\b)`)
 
 type Server struct {
-	l              net.Listener
-	activeConn     map[*conn]struct{}
-	isShuttingDown atomic.Bool
-	mu             sync.Mutex
+	*server.Server
+
+	logger   *slog.Logger
+	metrics  *metrics.Registry
+	requests *metrics.Requests
 }
 
-func NewServer(addr string) (*Server, error) {
-	l, err := net.Listen("tcp", addr)
-	if err != nil {
-		return nil, err
+type ServerOpt func(s *Server)
+
+func WithLogger(l *slog.Logger) ServerOpt {
+	return func(s *Server) {
+		s.logger = l
 	}
+}
 
-	return &Server{
-		l:          l,
-		activeConn: make(map[*conn]struct{}),
-		mu:         sync.Mutex{},
-	}, nil
+func WithMetrics(r *metrics.Registry) ServerOpt {
+	return func(s *Server) {
+		s.metrics = r
+	}
 }
 
-func (s *Server) ListenAndServe() {
-	for {
-		c, err := s.l.Accept()
-		if err != nil {
-			logger.Error(err.Error())
-			continue
-		}
+func NewServer(addr string, opts ...ServerOpt) (*Server, error) {
+	s := &Server{}
 
-		conn := &conn{
-			conn: c,
-			ip:   c.LocalAddr().String(),
-		}
+	for _, opt := range opts {
+		opt(s)
+	}
 
-		s.addConn(conn)
+	if s.logger == nil {
+		s.logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
 
-		go s.handle(conn)
+	if s.metrics == nil {
+		s.metrics = metrics.NewRegistry()
 	}
-}
 
-func (s *Server) Shutdown(ctx context.Context) error {
-	s.isShuttingDown.Store(true)
-	return nil
+	s.requests = s.metrics.Requests("mitm")
+
+	srv, err := server.New(addr, server.HandlerFunc(s.handle), server.WithLogger(s.logger), server.WithMetrics(s.metrics, "mitm"))
+	if err != nil {
+		return nil, err
+	}
+
+	s.Server = srv
+
+	return s, nil
 }
 
-func (s *Server) handle(c *conn) {
-	defer func() {
-		s.removeConn(c)
-		c.Close()
-	}()
+func (s *Server) handle(ctx context.Context, rwc net.Conn) {
+	c := &conn{
+		conn: rwc,
+		ip:   rwc.RemoteAddr().String(),
+	}
 
 	// Connect to upstream
 	upstream, err := net.Dial("tcp", "chat.protohackers.com:16963")
 	if err != nil {
-		logger.Error("failed to connect to upstream", "ip", c.ip)
+		s.logger.Error("failed to connect to upstream", "ip", c.ip)
 		return
 	}
 	defer upstream.Close()
@@ -81,15 +90,13 @@ func (s *Server) handle(c *conn) {
 		reader := bufio.NewReaderSize(upstream, 2048)
 
 		for {
-			if s.isShuttingDown.Load() {
-				return
-			}
-
 			line, err := reader.ReadString('\n')
 			if err != nil {
 				return
 			}
 
+			start := time.Now()
+
 			// check for wallet address
 			if reWalletAddress.MatchString(line) {
 				splitLine := strings.Split(line, " ")
@@ -103,10 +110,11 @@ func (s *Server) handle(c *conn) {
 						line = reWalletAddress.ReplaceAllString(line, tonyBogusCoinAddr)
 					}
 				}
-				logger.Info("modified wallet address", "line", line)
+				s.logger.Info("modified wallet address", "line", line)
 			}
 
 			io.Copy(c, strings.NewReader(line))
+			s.requests.Observe("upstream", start)
 		}
 	}()
 
@@ -114,8 +122,8 @@ func (s *Server) handle(c *conn) {
 	reader := bufio.NewReaderSize(c.conn, 2048)
 
 	for {
-		if s.isShuttingDown.Load() {
-			logger.Info("server shutting down, exiting handler", "ip", c.ip)
+		if ctx.Err() != nil {
+			s.logger.Info("server shutting down, exiting handler", "ip", c.ip)
 			return
 		}
 
@@ -123,14 +131,16 @@ func (s *Server) handle(c *conn) {
 		line, err := reader.ReadString('\n')
 		if err != nil {
 			if errors.Is(err, io.EOF) {
-				logger.Info("client disconnected", "ip", c.ip)
+				s.logger.Info("client disconnected", "ip", c.ip)
 				return
 			}
 
 			return
 		}
 
-		logger.Info("read line", "line", line, "ip", c.ip)
+		start := time.Now()
+
+		s.logger.Info("read line", "line", line, "ip", c.ip)
 
 		// check for wallet address
 		if reWalletAddress.MatchString(line) {
@@ -145,22 +155,11 @@ func (s *Server) handle(c *conn) {
 					line = reWalletAddress.ReplaceAllString(line, tonyBogusCoinAddr)
 				}
 			}
-			logger.Info("modified wallet address", "line", line)
+			s.logger.Info("modified wallet address", "line", line)
 		}
 
 		// write to upstream
 		io.Copy(upstream, strings.NewReader(line))
+		s.requests.Observe("client", start)
 	}
 }
-
-func (s *Server) addConn(c *conn) {
-	s.mu.Lock()
-	s.activeConn[c] = struct{}{}
-	s.mu.Unlock()
-}
-
-func (s *Server) removeConn(c *conn) {
-	s.mu.Lock()
-	delete(s.activeConn, c)
-	s.mu.Unlock()
-}
@@ -0,0 +1,265 @@
+// Command means-client bulk-loads prices into a Means to an End server and
+// queries it, either from the command line or interactively.
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bcatubig/protohackers/2_means_to_an_end/client"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: means-client [-addr host:port] [-session id | -namespace name] [command]
+
+commands:
+  load <file.csv>   inserts every timestamp,price row; - reads stdin
+  <query>           runs a single query, see below
+
+With no command, reads queries from stdin, one per line:
+`)
+	fmt.Fprint(os.Stderr, help)
+}
+
+const help = `  insert <timestamp> <price>
+  query <min> <max>           mean price
+  min|max|median|sum|count <min> <max>
+  percentile <min> <max> <n>
+  ohlc <min> <max> <width>
+  load <file.csv>
+  help
+  quit
+`
+
+func main() {
+	flagAddr := flag.String("addr", "127.0.0.1:8000", "server address")
+	flagSession := flag.String("session", "", "16 hex digit session id to attach to")
+	flagNamespace := flag.String("namespace", "", "shared namespace of up to 8 bytes to attach to")
+	flag.Usage = usage
+	flag.Parse()
+
+	// the server only honours one of them, as the first message
+	if *flagSession != "" && *flagNamespace != "" {
+		fmt.Fprint(os.Stderr, "-session and -namespace can't be combined\n\n")
+		usage()
+		os.Exit(2)
+	}
+
+	c, err := client.Dial(*flagAddr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	defer c.Close()
+
+	if *flagSession != "" {
+		var id [8]byte
+
+		if b, err := hex.DecodeString(*flagSession); err != nil || len(b) != len(id) {
+			fmt.Fprintln(os.Stderr, "session must be 16 hex digits")
+			os.Exit(2)
+		} else {
+			copy(id[:], b)
+		}
+
+		if err := c.Session(id); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if *flagNamespace != "" {
+		if err := c.Namespace(*flagNamespace); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if flag.NArg() > 0 {
+		if err := run(c, flag.Args()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	interactive(c)
+}
+
+func interactive(c *client.Client) {
+	scanner := bufio.NewScanner(os.Stdin)
+
+	fmt.Print("> ")
+
+	for scanner.Scan() {
+		args := strings.Fields(scanner.Text())
+
+		switch {
+		case len(args) == 0:
+		case args[0] == "quit" || args[0] == "exit":
+			return
+		case args[0] == "help":
+			fmt.Print(help)
+		default:
+			if err := run(c, args); err != nil {
+				fmt.Println("error:", err)
+			}
+		}
+
+		fmt.Print("> ")
+	}
+}
+
+// run executes a single command and prints its result.
+func run(c *client.Client, args []string) error {
+	cmd, args := args[0], args[1:]
+
+	if cmd == "load" {
+		if len(args) != 1 {
+			return errors.New("usage: load <file.csv>")
+		}
+
+		return load(c, args[0])
+	}
+
+	want := map[string]int{
+		"insert": 2, "query": 2, "min": 2, "max": 2, "median": 2, "sum": 2, "count": 2,
+		"percentile": 3, "ohlc": 3,
+	}
+
+	n, ok := want[cmd]
+	if !ok {
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+
+	if len(args) != n {
+		return fmt.Errorf("%s takes %d arguments", cmd, n)
+	}
+
+	nums := make([]int32, n)
+
+	for i, arg := range args {
+		v, err := strconv.ParseInt(arg, 10, 32)
+		if err != nil {
+			return err
+		}
+
+		nums[i] = int32(v)
+	}
+
+	var result any
+	var err error
+
+	switch cmd {
+	case "insert":
+		if err := c.Insert(nums[0], nums[1]); err != nil {
+			return err
+		}
+
+		return c.Flush()
+	case "query":
+		result, err = c.Query(nums[0], nums[1])
+	case "min":
+		result, err = c.Min(nums[0], nums[1])
+	case "max":
+		result, err = c.Max(nums[0], nums[1])
+	case "median":
+		result, err = c.Median(nums[0], nums[1])
+	case "sum":
+		result, err = c.Sum(nums[0], nums[1])
+	case "count":
+		result, err = c.Count(nums[0], nums[1])
+	case "percentile":
+		result, err = c.Percentile(nums[0], nums[1], nums[2])
+	case "ohlc":
+		candles, err := c.OHLC(nums[0], nums[1], nums[2])
+		if err != nil {
+			return err
+		}
+
+		for _, candle := range candles {
+			fmt.Printf("%d\t%d\t%d\t%d\t%d\n", candle.Start, candle.Open, candle.High, candle.Low, candle.Close)
+		}
+
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(result)
+
+	return nil
+}
+
+// load inserts every timestamp,price row of a CSV file. A first row that
+// isn't numeric is taken as a header and skipped.
+func load(c *client.Client, path string) error {
+	var in io.Reader = os.Stdin
+
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		defer f.Close()
+
+		in = f
+	}
+
+	r := csv.NewReader(bufio.NewReader(in))
+	r.FieldsPerRecord = 2
+	r.TrimLeadingSpace = true
+
+	start := time.Now()
+	count := 0
+
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		timestamp, err1 := strconv.ParseInt(record[0], 10, 32)
+		cents, err2 := strconv.ParseInt(record[1], 10, 32)
+
+		if err := errors.Join(err1, err2); err != nil {
+			if line == 1 {
+				continue
+			}
+
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+
+		if err := c.Insert(int32(timestamp), int32(cents)); err != nil {
+			return err
+		}
+
+		count++
+	}
+
+	if err := c.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Printf("loaded %d prices in %s\n", count, time.Since(start).Round(time.Millisecond))
+
+	return nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	smoketest "github.com/bcatubig/protohackers/0_smoketest"
+	prime "github.com/bcatubig/protohackers/1_prime"
+	means "github.com/bcatubig/protohackers/2_means_to_an_end"
+	chat "github.com/bcatubig/protohackers/3_budget_chat"
+	kv "github.com/bcatubig/protohackers/4_unusual_database_program"
+	mitm "github.com/bcatubig/protohackers/5_mob_in_the_middle"
+	"github.com/bcatubig/protohackers/metrics"
+	"github.com/bcatubig/protohackers/server"
+)
+
+var logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+type runner interface {
+	ListenAndServe() error
+	Shutdown(ctx context.Context) error
+}
+
+// config holds the settings shared by every challenge's server.
+type config struct {
+	logger  *slog.Logger
+	metrics *metrics.Registry
+	jsonrpc bool
+
+	meansData         string
+	meansSync         time.Duration
+	meansNamespaces   bool
+	meansMaxEntries   int
+	meansMaxNsEntries int
+	meansMemory       int64
+	meansRate         float64
+
+	meansRetention       int
+	meansDownsampleAfter int
+	meansDownsampleWidth int
+
+	chatHistory    int
+	chatHistoryDir string
+
+	chatQueue          int
+	chatDisconnectSlow bool
+	chatWebSocket      string
+}
+
+type challenge struct {
+	name      string
+	newServer func(addr string, cfg config) (runner, error)
+}
+
+// challenges are listed in protohackers order so "all" can hand out
+// consecutive ports.
+var challenges = []challenge{
+	{"smoke", func(addr string, cfg config) (runner, error) {
+		return asRunner(smoketest.NewServer(addr, smoketest.WithLogger(cfg.logger), smoketest.WithMetrics(cfg.metrics)))
+	}},
+	{"prime", func(addr string, cfg config) (runner, error) {
+		opts := []prime.ServerOpt{prime.WithLogger(cfg.logger), prime.WithMetrics(cfg.metrics)}
+
+		if cfg.jsonrpc {
+			opts = append(opts, prime.WithJSONRPC())
+		}
+
+		return asRunner(prime.NewServer(addr, opts...))
+	}},
+	{"means", func(addr string, cfg config) (runner, error) {
+		opts := []means.ServerOpt{means.WithLogger(cfg.logger), means.WithMetrics(cfg.metrics)}
+
+		if cfg.meansData != "" {
+			opts = append(opts, means.WithPersistence(cfg.meansData, 0), means.WithSyncInterval(cfg.meansSync))
+		}
+
+		if cfg.meansNamespaces {
+			opts = append(opts, means.WithNamespaces())
+		}
+
+		if cfg.meansMaxEntries > 0 {
+			opts = append(opts, means.WithMaxEntries(cfg.meansMaxEntries))
+		}
+
+		if cfg.meansMaxNsEntries > 0 {
+			opts = append(opts, means.WithMaxNamespaceEntries(cfg.meansMaxNsEntries))
+		}
+
+		if cfg.meansMemory > 0 {
+			opts = append(opts, means.WithMemoryBudget(cfg.meansMemory))
+		}
+
+		if cfg.meansRate > 0 {
+			opts = append(opts, means.WithRateLimit(cfg.meansRate, max(int(cfg.meansRate), 1)))
+		}
+
+		if cfg.meansRetention > 0 {
+			opts = append(opts, means.WithRetention(int32(cfg.meansRetention)))
+		}
+
+		if cfg.meansDownsampleWidth > 0 {
+			opts = append(opts, means.WithDownsampling(int32(cfg.meansDownsampleAfter), int32(cfg.meansDownsampleWidth)))
+		}
+
+		return asRunner(means.NewServer(addr, opts...))
+	}},
+	{"chat", func(addr string, cfg config) (runner, error) {
+		opts := []chat.ServerOpt{chat.WithLogger(cfg.logger), chat.WithMetrics(cfg.metrics)}
+
+		if cfg.chatDisconnectSlow {
+			opts = append(opts, chat.WithOutboundQueue(cfg.chatQueue, chat.Disconnect))
+		} else {
+			opts = append(opts, chat.WithOutboundQueue(cfg.chatQueue, chat.DropOldest))
+		}
+
+		if cfg.chatWebSocket != "" {
+			opts = append(opts, chat.WithWebSocket(cfg.chatWebSocket))
+		}
+
+		if cfg.chatHistory > 0 {
+			opts = append(opts, chat.WithHistory(cfg.chatHistory), chat.WithHistoryJournal(cfg.chatHistoryDir))
+		}
+
+		return asRunner(chat.NewServer(addr, opts...))
+	}},
+	{"kv", func(addr string, cfg config) (runner, error) {
+		return asRunner(kv.NewServer(addr, kv.WithLogger(cfg.logger), kv.WithMetrics(cfg.metrics)))
+	}},
+	{"mitm", func(addr string, cfg config) (runner, error) {
+		return asRunner(mitm.NewServer(addr, mitm.WithLogger(cfg.logger), mitm.WithMetrics(cfg.metrics)))
+	}},
+}
+
+func asRunner[S runner](s S, err error) (runner, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: protohackers <command> [flags]\n\nRun protohackers <command> -h to list the flags.\n\ncommands:\n")
+
+	for _, c := range challenges {
+		fmt.Fprintf(os.Stderr, "  %s\n", c.name)
+	}
+
+	fmt.Fprintf(os.Stderr, "  all\truns every challenge on consecutive ports starting at -p\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd := os.Args[1]
+
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	flagPort := fs.Int("p", 8000, "port to listen on")
+	flagMetrics := fs.String("metrics", "", "address to serve /metrics on, disabled when empty")
+	flagJSONRPC := fs.Bool("jsonrpc", false, "accept JSON-RPC 2.0 requests in the prime server")
+	flagMeansData := fs.String("means-data", "", "directory to persist means sessions in, in-memory only when empty")
+	flagMeansSync := fs.Duration("means-sync-interval", 0, "longest time between syncing means session logs to disk, after every insert when 0")
+	flagMeansNamespaces := fs.Bool("means-namespaces", false, "let means clients share prices through named namespaces")
+	flagMeansMaxEntries := fs.Int("means-max-entries", 0, "prices a single means session may hold, unlimited when 0")
+	flagMeansMaxNsEntries := fs.Int("means-max-namespace-entries", 0, "prices a means namespace may hold across all its clients, unlimited when 0")
+	flagMeansMemory := fs.Int64("means-memory", 0, "bytes all means prices may take up, unlimited when 0")
+	flagMeansRate := fs.Float64("means-rate", 0, "messages per second a means client may send, unlimited when 0")
+	flagMeansRetention := fs.Int("means-retention", 0, "seconds of means history to keep behind the newest price, everything when 0")
+	flagMeansDownsampleAfter := fs.Int("means-downsample-after", 0, "age in seconds past which means prices are downsampled")
+	flagMeansDownsampleWidth := fs.Int("means-downsample-width", 0, "width in seconds of downsampled means buckets, disabled when 0")
+	flagChatHistory := fs.Int("chat-history", 0, "messages per chat room replayed to people entering it, disabled when 0")
+	flagChatHistoryDir := fs.String("chat-history-dir", "", "directory to keep chat history in, in-memory only when empty")
+	flagChatQueue := fs.Int("chat-queue", 256, "messages that may wait to be written to a single chat client")
+	flagChatDisconnectSlow := fs.Bool("chat-disconnect-slow", false, "disconnect chat clients whose queue is full instead of dropping their oldest message")
+	flagChatWebSocket := fs.String("chat-ws", "", "address to accept chat clients over WebSocket on, disabled when empty")
+	fs.Parse(os.Args[2:])
+
+	var selected []challenge
+
+	if cmd == "all" {
+		selected = challenges
+	} else {
+		for _, c := range challenges {
+			if c.name == cmd {
+				selected = append(selected, c)
+			}
+		}
+	}
+
+	if len(selected) == 0 {
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n\n", cmd)
+		usage()
+		os.Exit(2)
+	}
+
+	chanSignal := make(chan os.Signal, 1)
+	signal.Notify(chanSignal, os.Interrupt, syscall.SIGTERM)
+
+	reg := metrics.NewRegistry()
+
+	var metricsSrv *http.Server
+
+	if *flagMetrics != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", reg.Handler())
+
+		metricsSrv = &http.Server{Addr: *flagMetrics, Handler: mux}
+
+		logger.Info("starting metrics server", "addr", *flagMetrics)
+
+		go func() {
+			err := metricsSrv.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logger.Error("metrics server error", "error", err.Error())
+			}
+		}()
+	}
+
+	cfg := config{
+		metrics:           reg,
+		jsonrpc:           *flagJSONRPC,
+		meansData:         *flagMeansData,
+		meansSync:         *flagMeansSync,
+		meansNamespaces:   *flagMeansNamespaces,
+		meansMaxEntries:   *flagMeansMaxEntries,
+		meansMaxNsEntries: *flagMeansMaxNsEntries,
+		meansMemory:       *flagMeansMemory,
+		meansRate:         *flagMeansRate,
+
+		meansRetention:       *flagMeansRetention,
+		meansDownsampleAfter: *flagMeansDownsampleAfter,
+		meansDownsampleWidth: *flagMeansDownsampleWidth,
+
+		chatHistory:    *flagChatHistory,
+		chatHistoryDir: *flagChatHistoryDir,
+
+		chatQueue:          *flagChatQueue,
+		chatDisconnectSlow: *flagChatDisconnectSlow,
+		chatWebSocket:      *flagChatWebSocket,
+	}
+
+	var servers []runner
+
+	for i, c := range selected {
+		addr := fmt.Sprintf("0.0.0.0:%d", *flagPort+i)
+		l := logger.With("challenge", c.name)
+
+		cfg.logger = l
+
+		svr, err := c.newServer(addr, cfg)
+
+		if err != nil {
+			logger.Error("error creating server", "challenge", c.name, "error", err.Error())
+			os.Exit(1)
+		}
+
+		l.Info("starting server", "addr", addr)
+
+		go func() {
+			err := svr.ListenAndServe()
+			if err != nil && !errors.Is(err, server.ErrServerClosed) {
+				l.Error(err.Error())
+			}
+		}()
+
+		servers = append(servers, svr)
+	}
+
+	<-chanSignal
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var wg sync.WaitGroup
+
+	for _, svr := range servers {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if err := svr.Shutdown(ctx); err != nil {
+				logger.Error(err.Error())
+			}
+		}()
+	}
+
+	if metricsSrv != nil {
+		if err := metricsSrv.Shutdown(ctx); err != nil {
+			logger.Error(err.Error())
+		}
+	}
+
+	wg.Wait()
+	logger.Info("shutdown complete")
+}
@@ -0,0 +1,161 @@
+// Package golden replays recorded client sessions against a server.Handler
+// and compares what it sends back with golden files.
+//
+// A session is a file in a testdata directory holding the bytes a client
+// sends, either verbatim in a .in file or as hex in a .hex file, where
+// whitespace and anything after a # on a line are ignored. What the server
+// sends back is compared with the file of the same name ending in .golden:
+// verbatim for .in sessions and as a hex dump for .hex sessions.
+//
+// Run the tests with -update to rewrite the golden files from the current
+// output.
+package golden
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"flag"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bcatubig/protohackers/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files with the current output")
+
+// Timeout bounds how long a single session may take.
+var Timeout = 10 * time.Second
+
+// Run replays every session in dir against h, each on its own connection,
+// as a subtest named after the session file.
+//
+// The client sends the whole session and then closes its side of the
+// connection, so h sees EOF once it has read everything. Everything h writes
+// until it returns is the session's output.
+func Run(t *testing.T, dir string, h server.Handler) {
+	t.Helper()
+
+	in, err := filepath.Glob(filepath.Join(dir, "*.in"))
+	require.NoError(t, err)
+
+	hexes, err := filepath.Glob(filepath.Join(dir, "*.hex"))
+	require.NoError(t, err)
+
+	sessions := slices.Concat(in, hexes)
+	slices.Sort(sessions)
+
+	if len(sessions) == 0 {
+		t.Fatalf("no sessions in %s", dir)
+	}
+
+	for _, path := range sessions {
+		name := filepath.Base(path)
+
+		t.Run(strings.TrimSuffix(name, filepath.Ext(name)), func(t *testing.T) {
+			isHex := filepath.Ext(path) == ".hex"
+
+			input, err := os.ReadFile(path)
+			require.NoError(t, err)
+
+			if isHex {
+				input, err = parseHex(input)
+				require.NoError(t, err, "parsing %s", path)
+			}
+
+			got := Replay(t, h, input)
+
+			if isHex {
+				got = []byte(hex.Dump(got))
+			}
+
+			goldenPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".golden"
+
+			if *update {
+				require.NoError(t, os.WriteFile(goldenPath, got, 0o644))
+				return
+			}
+
+			want, err := os.ReadFile(goldenPath)
+			require.NoError(t, err, "run with -update to create it")
+
+			assert.Equal(t, string(want), string(got))
+		})
+	}
+}
+
+// Replay sends input to h over a loopback connection and returns everything
+// h wrote back.
+func Replay(t *testing.T, h server.Handler, input []byte) []byte {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		defer c.Close()
+
+		h.ServeConn(ctx, c)
+	}()
+
+	c, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer c.Close()
+
+	c.SetDeadline(time.Now().Add(Timeout))
+
+	writeErr := make(chan error, 1)
+
+	go func() {
+		_, err := c.Write(input)
+
+		if err == nil {
+			err = c.(*net.TCPConn).CloseWrite()
+		}
+
+		writeErr <- err
+	}()
+
+	got, err := io.ReadAll(c)
+	require.NoError(t, err, "reading output")
+	require.NoError(t, <-writeErr, "writing input")
+
+	<-done
+
+	return got
+}
+
+func parseHex(data []byte) ([]byte, error) {
+	var digits bytes.Buffer
+
+	for line := range bytes.Lines(data) {
+		line, _, _ = bytes.Cut(line, []byte("#"))
+
+		for _, field := range bytes.Fields(line) {
+			digits.Write(field)
+		}
+	}
+
+	return hex.DecodeString(digits.String())
+}
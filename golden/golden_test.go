@@ -0,0 +1,39 @@
+package golden
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/bcatubig/protohackers/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var echo = server.HandlerFunc(func(ctx context.Context, c net.Conn) {
+	io.Copy(c, c)
+})
+
+func TestRun(t *testing.T) {
+	Run(t, "testdata", echo)
+}
+
+func TestReplay(t *testing.T) {
+	input := make([]byte, 1<<20)
+
+	for i := range input {
+		input[i] = byte(i)
+	}
+
+	assert.Equal(t, input, Replay(t, echo, input), "large sessions don't deadlock")
+}
+
+func TestParseHex(t *testing.T) {
+	got, err := parseHex([]byte("# comment\n49 0000 3039 # trailing\n\n00000065\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []byte{'I', 0, 0, 0x30, 0x39, 0, 0, 0, 0x65}, got)
+
+	_, err = parseHex([]byte("4"))
+	assert.Error(t, err)
+}
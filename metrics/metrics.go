@@ -0,0 +1,326 @@
+// Package metrics implements counters, gauges and histograms that can be
+// scraped in the Prometheus text exposition format without pulling in the
+// Prometheus client library.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefBuckets are the default histogram buckets, in seconds.
+var DefBuckets = []float64{.0005, .001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+type family struct {
+	name   string
+	help   string
+	typ    metricType
+	series map[string]any
+}
+
+// Registry holds every metric that is exposed by Handler.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]*family),
+	}
+}
+
+// Counter returns the counter identified by name and the given label
+// key/value pairs, creating it on first use.
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return r.get(name, help, counterType, labels, func() any {
+		return &Counter{}
+	}).(*Counter)
+}
+
+// Gauge returns the gauge identified by name and the given label key/value
+// pairs, creating it on first use.
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return r.get(name, help, gaugeType, labels, func() any {
+		return &Gauge{}
+	}).(*Gauge)
+}
+
+// Histogram returns the histogram identified by name and the given label
+// key/value pairs, creating it with DefBuckets on first use.
+func (r *Registry) Histogram(name, help string, labels ...string) *Histogram {
+	return r.get(name, help, histogramType, labels, func() any {
+		return newHistogram(DefBuckets)
+	}).(*Histogram)
+}
+
+func (r *Registry) get(name, help string, typ metricType, labels []string, create func() any) any {
+	if len(labels)%2 != 0 {
+		panic(fmt.Sprintf("metrics: odd number of label arguments for %s", name))
+	}
+
+	key := formatLabels(labels)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{
+			name:   name,
+			help:   help,
+			typ:    typ,
+			series: make(map[string]any),
+		}
+		r.families[name] = f
+	}
+
+	if f.typ != typ {
+		panic(fmt.Sprintf("metrics: %s already registered as a %s", name, f.typ))
+	}
+
+	m, ok := f.series[key]
+	if !ok {
+		m = create()
+		f.series[key] = m
+	}
+
+	return m
+}
+
+// WriteTo writes every registered metric in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+
+	for _, name := range names {
+		f := r.families[name]
+
+		fmt.Fprintf(&sb, "# HELP %s %s\n", f.name, f.help)
+		fmt.Fprintf(&sb, "# TYPE %s %s\n", f.name, f.typ)
+
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			switch m := f.series[key].(type) {
+			case *Counter:
+				writeSample(&sb, f.name, key, "", m.Value())
+			case *Gauge:
+				writeSample(&sb, f.name, key, "", m.Value())
+			case *Histogram:
+				m.write(&sb, f.name, key)
+			}
+		}
+	}
+
+	n, err := io.WriteString(w, sb.String())
+
+	return int64(n), err
+}
+
+// Handler serves the registry over HTTP.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteTo(w)
+	})
+}
+
+type Counter struct {
+	bits atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add increments the counter by v, which must not be negative.
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+
+	addFloat(&c.bits, v)
+}
+
+func (c *Counter) Value() float64 {
+	return math.Float64frombits(c.bits.Load())
+}
+
+type Gauge struct {
+	bits atomic.Uint64
+}
+
+func (g *Gauge) Set(v float64) {
+	g.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+func (g *Gauge) Add(v float64) {
+	addFloat(&g.bits, v)
+}
+
+func (g *Gauge) Value() float64 {
+	return math.Float64frombits(g.bits.Load())
+}
+
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+
+	h.sum += v
+	h.count++
+}
+
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.count
+}
+
+func (h *Histogram) write(sb *strings.Builder, name, labels string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, upper := range h.buckets {
+		writeSample(sb, name+"_bucket", labels, formatFloat(upper), float64(h.counts[i]))
+	}
+
+	writeSample(sb, name+"_bucket", labels, "+Inf", float64(h.count))
+	writeSample(sb, name+"_sum", labels, "", h.sum)
+	writeSample(sb, name+"_count", labels, "", float64(h.count))
+}
+
+func addFloat(bits *atomic.Uint64, v float64) {
+	for {
+		old := bits.Load()
+		next := math.Float64bits(math.Float64frombits(old) + v)
+
+		if bits.CompareAndSwap(old, next) {
+			return
+		}
+	}
+}
+
+func writeSample(sb *strings.Builder, name, labels, le string, v float64) {
+	if le != "" {
+		if labels != "" {
+			labels += ","
+		}
+		labels += `le="` + le + `"`
+	}
+
+	sb.WriteString(name)
+
+	if labels != "" {
+		sb.WriteString("{" + labels + "}")
+	}
+
+	sb.WriteString(" " + formatFloat(v) + "\n")
+}
+
+func formatLabels(labels []string) string {
+	pairs := make([]string, 0, len(labels)/2)
+
+	for i := 0; i < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", labels[i], strconv.Quote(labels[i+1])))
+	}
+
+	sort.Strings(pairs)
+
+	return strings.Join(pairs, ",")
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Requests records request counts, malformed requests and request latency
+// for a single server.
+type Requests struct {
+	r         *Registry
+	server    string
+	malformed *Counter
+}
+
+func (r *Registry) Requests(server string) *Requests {
+	return &Requests{
+		r:         r,
+		server:    server,
+		malformed: r.Counter("protohackers_malformed_requests_total", "Requests that could not be parsed.", "server", server),
+	}
+}
+
+// Observe counts a request of the given type that started at start.
+func (q *Requests) Observe(typ string, start time.Time) {
+	q.r.Counter("protohackers_requests_total", "Requests handled, by type.", "server", q.server, "type", typ).Inc()
+	q.r.Histogram("protohackers_request_duration_seconds", "Time spent handling a request.", "server", q.server, "type", typ).Observe(time.Since(start).Seconds())
+}
+
+func (q *Requests) Malformed() {
+	q.malformed.Inc()
+}
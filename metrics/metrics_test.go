@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry(t *testing.T) {
+	t.Run("get or create", func(t *testing.T) {
+		r := NewRegistry()
+
+		r.Counter("requests_total", "Requests.", "server", "means").Inc()
+		r.Counter("requests_total", "Requests.", "server", "means").Add(2)
+
+		assert.Equal(t, 3.0, r.Counter("requests_total", "Requests.", "server", "means").Value())
+		assert.Equal(t, 0.0, r.Counter("requests_total", "Requests.", "server", "prime").Value())
+	})
+
+	t.Run("type mismatch panics", func(t *testing.T) {
+		r := NewRegistry()
+		r.Counter("conns", "Connections.")
+
+		assert.Panics(t, func() {
+			r.Gauge("conns", "Connections.")
+		})
+	})
+
+	t.Run("text format", func(t *testing.T) {
+		r := NewRegistry()
+
+		g := r.Gauge("connections_active", "Connections currently open.", "server", "chat")
+		g.Inc()
+		g.Inc()
+		g.Dec()
+
+		h := r.Histogram("latency_seconds", "Latency.")
+		h.Observe(0.002)
+		h.Observe(20)
+
+		sb := &strings.Builder{}
+		r.WriteTo(sb)
+		out := sb.String()
+
+		assert.Contains(t, out, "# TYPE connections_active gauge\n")
+		assert.Contains(t, out, `connections_active{server="chat"} 1`+"\n")
+		assert.Contains(t, out, "# TYPE latency_seconds histogram\n")
+		assert.Contains(t, out, `latency_seconds_bucket{le="0.001"} 0`+"\n")
+		assert.Contains(t, out, `latency_seconds_bucket{le="0.005"} 1`+"\n")
+		assert.Contains(t, out, `latency_seconds_bucket{le="+Inf"} 2`+"\n")
+		assert.Contains(t, out, "latency_seconds_sum 20.002\n")
+		assert.Contains(t, out, "latency_seconds_count 2\n")
+	})
+
+	t.Run("requests", func(t *testing.T) {
+		r := NewRegistry()
+		q := r.Requests("means")
+
+		q.Observe("I", time.Now())
+		q.Observe("I", time.Now())
+		q.Malformed()
+
+		assert.Equal(t, 2.0, r.Counter("protohackers_requests_total", "", "server", "means", "type", "I").Value())
+		assert.Equal(t, uint64(2), r.Histogram("protohackers_request_duration_seconds", "", "server", "means", "type", "I").Count())
+		assert.Equal(t, 1.0, r.Counter("protohackers_malformed_requests_total", "", "server", "means").Value())
+	})
+}
@@ -0,0 +1,285 @@
+// Package server implements the TCP accept loop shared by the protohackers
+// challenges. Each challenge supplies a Handler with its protocol logic and
+// the server takes care of accepting, tracking and closing connections.
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bcatubig/protohackers/metrics"
+)
+
+// ErrServerClosed is returned by ListenAndServe after a call to Shutdown.
+var ErrServerClosed = errors.New("server: server closed")
+
+// Handler serves a single client connection. The connection is closed by the
+// server once ServeConn returns.
+//
+// ctx is cancelled when the server starts shutting down. Pending reads on c
+// are interrupted at that point so handlers can finish writing any in-flight
+// response and return.
+type Handler interface {
+	ServeConn(ctx context.Context, c net.Conn)
+}
+
+// HandlerFunc adapts an ordinary function to the Handler interface.
+type HandlerFunc func(ctx context.Context, c net.Conn)
+
+func (f HandlerFunc) ServeConn(ctx context.Context, c net.Conn) {
+	f(ctx, c)
+}
+
+type Server struct {
+	l       net.Listener
+	handler Handler
+	logger  *slog.Logger
+	name    string
+	metrics *metrics.Registry
+
+	connActive   *metrics.Gauge
+	connTotal    *metrics.Counter
+	connDuration *metrics.Histogram
+	bytesIn      *metrics.Counter
+	bytesOut     *metrics.Counter
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	inShutdown atomic.Bool
+	activeConn map[net.Conn]struct{}
+	wg         sync.WaitGroup
+	mu         sync.Mutex
+}
+
+type Opt func(s *Server)
+
+func WithListener(l net.Listener) Opt {
+	return func(s *Server) {
+		s.l = l
+	}
+}
+
+func WithLogger(l *slog.Logger) Opt {
+	return func(s *Server) {
+		s.logger = l
+	}
+}
+
+// WithMetrics records connection metrics in r, labelled with the server name.
+func WithMetrics(r *metrics.Registry, name string) Opt {
+	return func(s *Server) {
+		s.metrics = r
+		s.name = name
+	}
+}
+
+// New creates a server listening on addr, or on the listener given with
+// WithListener, that dispatches every accepted connection to h.
+func New(addr string, h Handler, opts ...Opt) (*Server, error) {
+	s := &Server{
+		handler:    h,
+		activeConn: make(map[net.Conn]struct{}),
+	}
+
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if s.l == nil {
+		l, err := net.Listen("tcp", addr)
+
+		if err != nil {
+			return nil, err
+		}
+
+		s.l = l
+	}
+
+	if s.logger == nil {
+		s.logger = slog.New(slog.NewJSONHandler(os.Stdout, nil))
+	}
+
+	if s.metrics == nil {
+		s.metrics = metrics.NewRegistry()
+	}
+
+	s.connActive = s.metrics.Gauge("protohackers_connections_active", "Connections currently open.", "server", s.name)
+	s.connTotal = s.metrics.Counter("protohackers_connections_total", "Connections accepted.", "server", s.name)
+	s.connDuration = s.metrics.Histogram("protohackers_connection_duration_seconds", "Time a connection stayed open.", "server", s.name)
+	s.bytesIn = s.metrics.Counter("protohackers_bytes_received_total", "Bytes read from clients.", "server", s.name)
+	s.bytesOut = s.metrics.Counter("protohackers_bytes_sent_total", "Bytes written to clients.", "server", s.name)
+
+	return s, nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.l.Addr()
+}
+
+// ListenAndServe accepts connections until the listener is closed. After
+// Shutdown it always returns ErrServerClosed.
+func (s *Server) ListenAndServe() error {
+	if s.inShutdown.Load() {
+		return ErrServerClosed
+	}
+
+	for {
+		c, err := s.l.Accept()
+
+		if err != nil {
+			if s.inShutdown.Load() {
+				return ErrServerClosed
+			}
+
+			if errors.Is(err, net.ErrClosed) {
+				return err
+			}
+
+			s.logger.Error("accept error", "error", err.Error())
+			continue
+		}
+
+		c = &countingConn{Conn: c, in: s.bytesIn, out: s.bytesOut}
+
+		if !s.addConn(c) {
+			c.Close()
+			continue
+		}
+
+		go s.serve(c)
+	}
+}
+
+// Serve handles c, accepted somewhere other than the server's listener, like
+// any other connection and returns once the handler is done with it. It
+// returns ErrServerClosed without calling the handler after Shutdown.
+func (s *Server) Serve(c net.Conn) error {
+	c = &countingConn{Conn: c, in: s.bytesIn, out: s.bytesOut}
+
+	if !s.addConn(c) {
+		c.Close()
+		return ErrServerClosed
+	}
+
+	s.serve(c)
+
+	return nil
+}
+
+// Shutdown stops accepting new connections, signals every active handler to
+// finish and waits for them to return. If ctx expires first the remaining
+// connections are force-closed and the context's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.inShutdown.Store(true)
+	s.mu.Unlock()
+
+	s.logger.Info("shutting down server", "addr", s.l.Addr().String())
+
+	err := s.l.Close()
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+
+	s.cancel()
+
+	chanDone := make(chan struct{})
+
+	go func() {
+		s.wg.Wait()
+		close(chanDone)
+	}()
+
+	select {
+	case <-chanDone:
+		s.logger.Info("all connections drained", "addr", s.l.Addr().String())
+	case <-ctx.Done():
+		s.logger.Error("timed out waiting for connections to drain", "addr", s.l.Addr().String())
+		s.closeConns()
+		return ctx.Err()
+	}
+
+	return err
+}
+
+func (s *Server) serve(c net.Conn) {
+	start := time.Now()
+
+	defer func() {
+		c.Close()
+		s.removeConn(c)
+		s.connDuration.Observe(time.Since(start).Seconds())
+	}()
+
+	stop := context.AfterFunc(s.ctx, func() {
+		c.SetReadDeadline(time.Now())
+	})
+	defer stop()
+
+	s.handler.ServeConn(s.ctx, c)
+}
+
+func (s *Server) addConn(c net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.inShutdown.Load() {
+		return false
+	}
+
+	s.activeConn[c] = struct{}{}
+	s.wg.Add(1)
+	s.connActive.Inc()
+	s.connTotal.Inc()
+
+	return true
+}
+
+func (s *Server) removeConn(c net.Conn) {
+	s.mu.Lock()
+	delete(s.activeConn, c)
+	s.mu.Unlock()
+
+	s.connActive.Dec()
+
+	s.wg.Done()
+}
+
+func (s *Server) closeConns() {
+	s.mu.Lock()
+	for c := range s.activeConn {
+		c.Close()
+	}
+	s.mu.Unlock()
+}
+
+// countingConn records the bytes read from and written to a connection.
+type countingConn struct {
+	net.Conn
+	in  *metrics.Counter
+	out *metrics.Counter
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.in.Add(float64(n))
+
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.out.Add(float64(n))
+
+	return n, err
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestServer(t *testing.T, h Handler) *Server {
+	t.Helper()
+
+	s, err := New("127.0.0.1:0", h)
+	require.NoError(t, err)
+
+	return s
+}
+
+func echoHandler(ctx context.Context, c net.Conn) {
+	io.Copy(c, c)
+}
+
+func TestServer(t *testing.T) {
+	t.Run("serves connections", func(t *testing.T) {
+		s := newTestServer(t, HandlerFunc(echoHandler))
+
+		go s.ListenAndServe()
+		defer s.Shutdown(context.Background())
+
+		c, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer c.Close()
+
+		_, err = c.Write([]byte("hello\n"))
+		require.NoError(t, err)
+
+		line, err := bufio.NewReader(c).ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "hello\n", line)
+	})
+
+	t.Run("shutdown closes listener and connections", func(t *testing.T) {
+		s := newTestServer(t, HandlerFunc(echoHandler))
+
+		chanErr := make(chan error, 1)
+		go func() {
+			chanErr <- s.ListenAndServe()
+		}()
+
+		c, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer c.Close()
+
+		// make sure the connection has been accepted before shutting down
+		_, err = c.Write([]byte("ping\n"))
+		require.NoError(t, err)
+		_, err = bufio.NewReader(c).ReadString('\n')
+		require.NoError(t, err)
+
+		require.NoError(t, s.Shutdown(context.Background()))
+
+		select {
+		case err := <-chanErr:
+			assert.ErrorIs(t, err, ErrServerClosed)
+		case <-time.After(time.Second):
+			t.Fatal("ListenAndServe did not return")
+		}
+
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = c.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("shutdown waits for in-flight handlers", func(t *testing.T) {
+		chanStarted := make(chan struct{})
+		chanFinished := make(chan struct{})
+
+		s := newTestServer(t, HandlerFunc(func(ctx context.Context, c net.Conn) {
+			close(chanStarted)
+			<-ctx.Done()
+			time.Sleep(50 * time.Millisecond)
+			close(chanFinished)
+		}))
+
+		go s.ListenAndServe()
+
+		c, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer c.Close()
+
+		<-chanStarted
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		require.NoError(t, s.Shutdown(ctx))
+
+		select {
+		case <-chanFinished:
+		default:
+			t.Fatal("Shutdown returned before handler finished")
+		}
+
+		assert.ErrorIs(t, s.ListenAndServe(), ErrServerClosed)
+	})
+
+	t.Run("shutdown force closes stragglers", func(t *testing.T) {
+		chanStarted := make(chan struct{})
+		chanRelease := make(chan struct{})
+
+		s := newTestServer(t, HandlerFunc(func(ctx context.Context, c net.Conn) {
+			close(chanStarted)
+			<-chanRelease
+		}))
+		defer close(chanRelease)
+
+		go s.ListenAndServe()
+
+		c, err := net.Dial("tcp", s.Addr().String())
+		require.NoError(t, err)
+		defer c.Close()
+
+		<-chanStarted
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		assert.ErrorIs(t, s.Shutdown(ctx), context.DeadlineExceeded)
+
+		c.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = c.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, io.EOF)
+	})
+
+	t.Run("serves connections accepted elsewhere", func(t *testing.T) {
+		s := newTestServer(t, HandlerFunc(echoHandler))
+
+		client, srv := net.Pipe()
+		defer client.Close()
+
+		chanErr := make(chan error, 1)
+		go func() {
+			chanErr <- s.Serve(srv)
+		}()
+
+		go client.Write([]byte("hello\n"))
+
+		line, err := bufio.NewReader(client).ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, "hello\n", line)
+
+		require.NoError(t, s.Shutdown(context.Background()))
+		assert.NoError(t, <-chanErr)
+
+		_, srv = net.Pipe()
+		assert.ErrorIs(t, s.Serve(srv), ErrServerClosed)
+	})
+}